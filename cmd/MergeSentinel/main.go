@@ -14,19 +14,25 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"flag"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/mux"
-  "github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"github.com/cropalato/MergeSentinel/internal/varenv"
 	"github.com/cropalato/MergeSentinel/internal/webservices"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
 func main() {
-  zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	listen := flag.String("listen", varenv.LookupEnvOrString("GLCE_APPROV_LISTEN", ":8080"), "IP and port used by the service. format: '[<ip>]:<port>'. default: ':8080'")
 	// the dafault password is 'admin'. ypu can use create a new one using
 	// python -c 'import bcrypt; print(bcrypt.hashpw(b"PASSWORD", bcrypt.gensalt(rounds=15)).decode("ascii"))'
@@ -40,13 +46,13 @@ func main() {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
-	cfg, _ := webservices.LoadConfig(*cfg_path)
-
-	err := cfg.ReinforceAllMrRule()
+	cfg, err := webservices.LoadConfig(*cfg_path)
 	if err != nil {
-		log.Fatal().Msg("Failed updating database")
+		log.Fatal().Err(err).Msg("Failed loading config")
 	}
-	// Call all projects in config file and reinforce merge approval rule
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	srv := http.Server{
 		Addr:              *listen,
@@ -59,8 +65,66 @@ func main() {
 	r := mux.NewRouter()
 	r.Use(mux.CORSMethodMiddleware(r))
 	r.HandleFunc("/state", cfg.State).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/livez", cfg.Livez).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", cfg.Readyz).Methods(http.MethodGet)
+	r.Handle("/debug/vars", expvar.Handler()).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/approve", cfg.PostApproval).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/api/v1/status_check", cfg.PostStatusCheck).Methods(http.MethodPost, http.MethodOptions)
 	http.Handle("/", r)
-  log.Info().Str("listening", *listen).Msg("Starting http service")
-	srv.ListenAndServe()
+	// Serve /livez and /readyz, not ready yet, during the startup checks
+	log.Info().Str("listening", *listen).Msg("Starting http service")
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("Failed starting http service")
+		}
+	}()
+
+	if err := cfg.CheckCompatibility(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Unsupported gitlab database")
+	}
+
+	var wg sync.WaitGroup
+	// Register the external status checks, again while it fails
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cfg.RunStatusCheckRegistration(ctx)
+	}()
+	// Reinforce again all MR rules when a freeze window opens or closes
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cfg.WatchFreezes(ctx)
+	}()
+	// Process the queued webhook events, including the ones left before a restart
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cfg.RunWebhookWorkers(ctx)
+	}()
+	// Check again the db enforcer compatibility, e.g. if gitlab was unreachable
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cfg.RunCompatibilityChecks(ctx)
+	}()
+	// Reinforce all MR rules at startup, then periodically correct the MRs
+	// whose state drifted, e.g. lost webhooks
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cfg.RunReconciler(ctx)
+	}()
+
+	<-ctx.Done()
+	log.Info().Msg("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Err(err).Msg("Failed stopping http service")
+	}
+	wg.Wait()
+	if err := cfg.Close(); err != nil {
+		log.Err(err).Msg("Failed closing service")
+	}
 }
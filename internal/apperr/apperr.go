@@ -0,0 +1,120 @@
+//
+// apperr.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package apperr classifies the errors of the service by kind: invalid
+// configuration, upstream gitlab failure, database failure or policy
+// violation. Use errors.Is with ErrConfig, ErrUpstream, ErrDatabase or
+// ErrPolicy to check the kind of an error.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Kind is the kind of an error.
+type Kind int
+
+const (
+	// Unknown is the kind of the errors not classified.
+	Unknown Kind = iota
+	// Config is an invalid or missing configuration.
+	Config
+	// Upstream is a failure of the gitlab API.
+	Upstream
+	// Database is a failure of the gitlab database.
+	Database
+	// Policy is a request refused by the rules, e.g. an invalid webhook
+	// token.
+	Policy
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Config:
+		return "config"
+	case Upstream:
+		return "upstream"
+	case Database:
+		return "database"
+	case Policy:
+		return "policy"
+	}
+	return "unknown"
+}
+
+// kindError is the sentinel error of a kind.
+type kindError Kind
+
+func (e kindError) Error() string { return Kind(e).String() + " error" }
+
+var (
+	// ErrConfig is matched by the errors of kind Config.
+	ErrConfig error = kindError(Config)
+	// ErrUpstream is matched by the errors of kind Upstream.
+	ErrUpstream error = kindError(Upstream)
+	// ErrDatabase is matched by the errors of kind Database.
+	ErrDatabase error = kindError(Database)
+	// ErrPolicy is matched by the errors of kind Policy.
+	ErrPolicy error = kindError(Policy)
+)
+
+// Error is an error of a known kind.
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+// Wrap classifies err as kind. It returns nil if err is nil, and err if it
+// already has a kind.
+func Wrap(kind Kind, err error) error {
+	if err == nil || KindOf(err) != Unknown {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// New returns an error of kind with the message msg.
+func New(kind Kind, msg string) error {
+	return &Error{Kind: kind, Err: errors.New(msg)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel error of the kind.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(kindError)
+	return ok && Kind(k) == e.Kind
+}
+
+// KindOf returns the kind of err, Unknown if not classified.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Unknown
+}
+
+// HTTPStatus returns the http status code reporting err.
+func HTTPStatus(err error) int {
+	switch KindOf(err) {
+	case Upstream:
+		return http.StatusBadGateway
+	case Database:
+		return http.StatusServiceUnavailable
+	case Policy:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,45 @@
+//
+// apperr_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWrap tests the classification of errors, through wrapping and
+// joining.
+func TestWrap(t *testing.T) {
+	assert.Nil(t, Wrap(Upstream, nil))
+	err := Wrap(Database, io.ErrUnexpectedEOF)
+	assert.ErrorIs(t, err, ErrDatabase)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.NotErrorIs(t, err, ErrUpstream)
+	assert.Equal(t, io.ErrUnexpectedEOF.Error(), err.Error())
+	// the first kind is kept
+	assert.Equal(t, Database, KindOf(Wrap(Upstream, err)))
+	wrapped := fmt.Errorf("updating MR 7: %w", err)
+	assert.Equal(t, Database, KindOf(wrapped))
+	assert.Equal(t, Policy, KindOf(errors.Join(New(Policy, "bad token"), err)))
+	assert.Equal(t, Unknown, KindOf(io.EOF))
+	assert.Equal(t, "unknown", KindOf(nil).String())
+}
+
+// TestHTTPStatus tests the http status of every kind.
+func TestHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(New(Config, "no token")))
+	assert.Equal(t, http.StatusBadGateway, HTTPStatus(New(Upstream, "gitlab down")))
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(New(Database, "db down")))
+	assert.Equal(t, http.StatusForbidden, HTTPStatus(New(Policy, "bad token")))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(io.EOF))
+}
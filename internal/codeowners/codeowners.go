@@ -0,0 +1,133 @@
+//
+// codeowners.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package codeowners parses CODEOWNERS files and matches their patterns
+// against repository paths.
+package codeowners
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Rule is a CODEOWNERS entry: a path pattern and the owners of the paths
+// matching it.
+type Rule struct {
+	Pattern string
+	Owners  []string
+	// Regexp is the compiled Pattern.
+	Regexp *regexp.Regexp
+}
+
+// Compile converts a CODEOWNERS/gitignore like pattern into a regexp
+// matching repository paths (without leading slash).
+//
+//   - a pattern starting with "/" or containing a "/" is anchored at the
+//     repository root, otherwise it matches at any depth;
+//   - a pattern ending with "/" matches everything under that directory;
+//   - "*" and "?" do not match "/", "**" matches any number of directories.
+func Compile(pattern string) (*regexp.Regexp, error) {
+	p := strings.TrimSpace(pattern)
+	if p == "" || p == "/" {
+		return nil, errors.Errorf("invalid empty pattern '%s'", pattern)
+	}
+	dirOnly := strings.HasSuffix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '*' && strings.HasPrefix(p[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(p[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '\\' && i+1 < len(p):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(p[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if dirOnly {
+		re.WriteString("/.*$")
+	} else {
+		re.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(re.String())
+}
+
+// Match reports if file matches the CODEOWNERS pattern. Invalid patterns
+// never match.
+func Match(pattern string, file string) bool {
+	re, err := Compile(pattern)
+	if err != nil {
+		return false
+	}
+	return MatchRegexp(re, file)
+}
+
+// MatchRegexp reports if file matches the pattern compiled by Compile.
+func MatchRegexp(re *regexp.Regexp, file string) bool {
+	return re.MatchString(strings.TrimPrefix(file, "/"))
+}
+
+// Parse reads CODEOWNERS content. Comments, empty lines and section headers
+// ("[Section]") are ignored, as are entries without owners.
+func Parse(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") ||
+			strings.HasPrefix(text, "[") || strings.HasPrefix(text, "^[") {
+			continue
+		}
+		if i := strings.Index(text, " #"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			continue
+		}
+		re, err := Compile(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		rules = append(rules, Rule{Pattern: fields[0], Owners: fields[1:], Regexp: re})
+	}
+	return rules, scanner.Err()
+}
+
+// ParseFile reads and parses the CODEOWNERS file at path.
+func ParseFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed loading CODEOWNERS file")
+	}
+	defer f.Close()
+	return Parse(f)
+}
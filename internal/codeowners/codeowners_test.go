@@ -0,0 +1,64 @@
+//
+// codeowners_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package codeowners
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMatch tests the supported pattern syntax.
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"db/migrations/", "db/migrations/001_init.sql", true},
+		{"db/migrations/", "db/migrations", false},
+		{"db/migrations/", "app/db/migrations/001.sql", false},
+		{"/docs", "docs/index.md", true},
+		{"docs", "src/docs/index.md", true},
+		{"*.sql", "schema/tables.sql", true},
+		{"*.sql", "schema/tables.go", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/pkg/main.go", false},
+		{"src/**/*.go", "src/pkg/sub/main.go", true},
+		{"src/**/*.go", "src/main.go", true},
+		{"/README.md", "README.md", true},
+		{"/README.md", "docs/README.md", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, Match(c.pattern, c.file), "pattern '%s' file '%s'", c.pattern, c.file)
+	}
+}
+
+// TestParse tests parsing a CODEOWNERS file.
+func TestParse(t *testing.T) {
+	content := `
+# comment
+[Database]
+db/migrations/ @dba1 @org/dba   # inline comment
+*.md @writer
+
+orphan-pattern
+`
+	rules, err := Parse(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, MatchRegexp(rules[0].Regexp, "/db/migrations/001.sql"))
+	assert.True(t, MatchRegexp(rules[1].Regexp, "docs/README.md"))
+	for i := range rules {
+		rules[i].Regexp = nil
+	}
+	assert.Equal(t, []Rule{
+		{Pattern: "db/migrations/", Owners: []string{"@dba1", "@org/dba"}},
+		{Pattern: "*.md", Owners: []string{"@writer"}},
+	}, rules)
+}
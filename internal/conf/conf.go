@@ -8,32 +8,443 @@
 package conf
 
 import (
-	"os"
-	"io"
 	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/cropalato/MergeSentinel/internal/codeowners"
+	"github.com/cropalato/MergeSentinel/internal/freeze"
+	validate "github.com/go-playground/validator/v10"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	validate "github.com/go-playground/validator/v10"
 )
 
+const (
+	// DefaultActionAllow lets MRs targeting a branch without rule be merged.
+	DefaultActionAllow = "allow"
+	// DefaultActionBlock keeps MRs targeting a branch without rule blocked.
+	DefaultActionBlock = "block"
+)
+
+const (
+	// EnforcerDB writes the merge status straight into the gitlab database.
+	EnforcerDB = "db"
+	// EnforcerCommitStatus posts a commit status on the MR head commit.
+	EnforcerCommitStatus = "commit_status"
+	// EnforcerStatusCheck answers the gitlab external status check.
+	EnforcerStatusCheck = "external_status_check"
+)
+
+const (
+	// DBIncompatibleReadOnly keeps the db enforcer from writing when the
+	// gitlab version or database schema is not supported.
+	DBIncompatibleReadOnly = "read_only"
+	// DBIncompatibleRefuse stops the service when the gitlab version or
+	// database schema is not supported by the db enforcer.
+	DBIncompatibleRefuse = "refuse"
+)
+
+// GroupPrefix marks an approver entry referencing a gitlab group, by full
+// path, instead of a username. e.g. "@group/backend-leads".
+const GroupPrefix = "@"
+
+// DefaultGroupCacheTTL is used when group_cache_ttl is not defined.
+const DefaultGroupCacheTTL = 300
+
+// DefaultQueueDir is where the webhook queue is persisted when state_dir is
+// not defined, created if missing.
+const DefaultQueueDir = "queue"
+
+const (
+	// DefaultReconcileInterval is used when reconcile_interval is not defined.
+	DefaultReconcileInterval = 600
+	// DefaultReconcileJitter is used when reconcile_jitter is not defined. 0
+	// disables the jitter.
+	DefaultReconcileJitter = 60
+	// DefaultReconcileWorkers is used when reconcile_workers is not defined.
+	DefaultReconcileWorkers = 4
+)
+
+const (
+	// DefaultWebhookWorkers is used when webhook_workers is not defined.
+	DefaultWebhookWorkers = 4
+	// DefaultWebhookRetries is used when webhook_retries is not defined.
+	DefaultWebhookRetries = 8
+	// DefaultWebhookDebounce is used when webhook_debounce is not defined.
+	DefaultWebhookDebounce = 500
+	// DefaultReadyMaxBacklog is used when ready_max_backlog is not defined.
+	DefaultReadyMaxBacklog = 1000
+)
+
+const (
+	// DefaultDBMaxOpenConns is used when db_max_open_conns is not defined.
+	DefaultDBMaxOpenConns = 5
+	// DefaultDBMaxIdleConns is used when db_max_idle_conns is not defined.
+	DefaultDBMaxIdleConns = 2
+	// DefaultDBConnMaxLifetime is used when db_conn_max_lifetime is not
+	// defined.
+	DefaultDBConnMaxLifetime = 300
+)
+
+// ApproverGroup is a named set of approvers with its own quorum. An MR
+// governed by several groups needs the quorum of every group.
+type ApproverGroup struct {
+	Name      string   `json:"name"       validate:"required"`
+	Approvals []string `json:"approvals"  validate:"gt=0,required"`
+	MinApprov int      `json:"min_approv" validate:"gt=0,required"`
+}
+
+// LabelRule changes the evaluation of MRs having Label. A Block label always
+// keeps the MR blocked. A label with MinApprov lowers the min_approv of the
+// branch rule groups (the lowest wins when several labels apply) and,
+// if Approvals is defined, also requires one approval from Approvals; these
+// labels are recorded as bypasses.
+type LabelRule struct {
+	Label     string   `json:"label"                validate:"required"`
+	Block     bool     `json:"block,omitempty"`
+	MinApprov int      `json:"min_approv,omitempty" validate:"omitempty,gt=0"`
+	Approvals []string `json:"approvals,omitempty"  validate:"omitempty,gt=0"`
+}
+
+// IsBypass reports if the label relaxes the approval requirements.
+func (l LabelRule) IsBypass() bool {
+	return !l.Block && l.MinApprov > 0
+}
+
+// BranchRule is an approval rule applied only to MRs whose target branch
+// matches Pattern. Pattern uses path.Match syntax, e.g. "main" or "release/*".
+type BranchRule struct {
+	Pattern           string          `json:"pattern"                      validate:"required"`
+	Approvals         []string        `json:"approvals,omitempty"          validate:"required_without=Groups,omitempty,gt=0"`
+	MinApprov         int             `json:"min_approv,omitempty"         validate:"required_with=Approvals,omitempty,gt=0"`
+	Groups            []ApproverGroup `json:"groups,omitempty"             validate:"omitempty,dive"`
+	ExcludeAuthor     bool            `json:"exclude_author,omitempty"`
+	ExcludeCommitters bool            `json:"exclude_committers,omitempty"`
+	ResetOnPush       bool            `json:"reset_on_push,omitempty"`
+	BlockDraft        bool            `json:"block_draft,omitempty"`
+	BlockConflicts    bool            `json:"block_conflicts,omitempty"`
+	BlockDiscussions  bool            `json:"block_unresolved_discussions,omitempty"`
+	RequirePipeline   bool            `json:"require_pipeline_success,omitempty"`
+	Labels            []LabelRule     `json:"labels,omitempty"             validate:"omitempty,dive"`
+}
+
+// ApproverGroups returns all groups whose quorum is required by the rule.
+// The flat approvals/min_approv pair, if defined, is returned as a group
+// without name.
+func (br BranchRule) ApproverGroups() []ApproverGroup {
+	groups := make([]ApproverGroup, 0, len(br.Groups)+1)
+	if len(br.Approvals) > 0 {
+		groups = append(groups, ApproverGroup{Approvals: br.Approvals, MinApprov: br.MinApprov})
+	}
+	return append(groups, br.Groups...)
+}
+
+const (
+	// StrategyRoundRobin assigns reviewers in turn.
+	StrategyRoundRobin = "round_robin"
+	// StrategyRandom assigns random reviewers.
+	StrategyRandom = "random"
+	// StrategyLeastLoaded assigns the reviewers with less opened MRs to review.
+	StrategyLeastLoaded = "least_loaded"
+)
+
+// ReviewerAssignment enables the assignment of missing approvers as
+// reviewers when a MR is opened.
+type ReviewerAssignment struct {
+	Strategy string `json:"strategy" validate:"required,oneof=round_robin random least_loaded"`
+}
+
+// PathRule requires approvals from Approvals when the MR changes a file
+// matching Pattern. Pattern uses the CODEOWNERS syntax, e.g. "db/migrations/".
+type PathRule struct {
+	Pattern   string   `json:"pattern"              validate:"required"`
+	Approvals []string `json:"approvals"            validate:"gt=0,required"`
+	MinApprov int      `json:"min_approv,omitempty" validate:"omitempty,gt=0"`
+	// Regexp is the pattern compiled when loading the config.
+	Regexp *regexp.Regexp `json:"-"`
+}
+
+// Match reports if the file matches the rule pattern.
+func (pr PathRule) Match(file string) bool {
+	if pr.Regexp == nil {
+		return codeowners.Match(pr.Pattern, file)
+	}
+	return codeowners.MatchRegexp(pr.Regexp, file)
+}
+
 type ApprovRule struct {
-	ProjectId int       `json:"project_id"              validate:"gt=0,required"`
-	Approvals []string  `json:"approvals"               validate:"gt=0,required"`
-	MinApprov int       `json:"min_approv"              validate:"gt=0,required"`
-	WebHookToken string `json:"webhook_token,omitempty" validate:"omitempty,gt=0"`
+	ProjectId      int                 `json:"project_id"                 validate:"gt=0,required"`
+	Approvals      []string            `json:"approvals,omitempty"        validate:"required_without_all=Branches Groups Paths CodeOwnersFile,omitempty,gt=0"`
+	MinApprov      int                 `json:"min_approv,omitempty"       validate:"required_with=Approvals,omitempty,gt=0"`
+	Groups         []ApproverGroup     `json:"groups,omitempty"           validate:"omitempty,dive"`
+	Branches       []BranchRule        `json:"branches,omitempty"         validate:"omitempty,dive"`
+	DefaultAction  string              `json:"default_action,omitempty"   validate:"omitempty,oneof=allow block"`
+	Paths          []PathRule          `json:"paths,omitempty"            validate:"omitempty,dive"`
+	CodeOwnersFile string              `json:"codeowners_file,omitempty"  validate:"omitempty,file"`
+	WebHookToken   string              `json:"webhook_token,omitempty"    validate:"omitempty,gt=0"`
+	Enforcer       string              `json:"enforcer,omitempty"         validate:"omitempty,oneof=db commit_status external_status_check"`
+	SummaryNote    bool                `json:"summary_note,omitempty"`
+	Reviewers      *ReviewerAssignment `json:"assign_reviewers,omitempty" validate:"omitempty"`
+	// The options below are the default for every branch rule of the project.
+	ExcludeAuthor     bool        `json:"exclude_author,omitempty"`
+	ExcludeCommitters bool        `json:"exclude_committers,omitempty"`
+	ResetOnPush       bool        `json:"reset_on_push,omitempty"`
+	BlockDraft        bool        `json:"block_draft,omitempty"`
+	BlockConflicts    bool        `json:"block_conflicts,omitempty"`
+	BlockDiscussions  bool        `json:"block_unresolved_discussions,omitempty"`
+	RequirePipeline   bool        `json:"require_pipeline_success,omitempty"`
+	Labels            []LabelRule `json:"labels,omitempty"           validate:"omitempty,dive"`
+	// CodeOwners holds the rules loaded from CodeOwnersFile.
+	CodeOwners []PathRule `json:"-"`
+}
+
+// codeOwnersRules converts CODEOWNERS entries into path rules. "@user"
+// owners become usernames, "@group/path" owners are kept as group
+// references and email owners are ignored.
+func codeOwnersRules(rules []codeowners.Rule) []PathRule {
+	var paths []PathRule
+	for _, r := range rules {
+		var approvals []string
+		for _, o := range r.Owners {
+			if !strings.HasPrefix(o, "@") {
+				continue
+			}
+			if strings.Contains(o, "/") {
+				approvals = append(approvals, GroupPrefix+strings.TrimPrefix(o, "@"))
+			} else {
+				approvals = append(approvals, strings.TrimPrefix(o, "@"))
+			}
+		}
+		if len(approvals) > 0 {
+			paths = append(paths, PathRule{Pattern: r.Pattern, Approvals: approvals, MinApprov: 1, Regexp: r.Regexp})
+		}
+	}
+	return paths
+}
+
+// PathGroups returns the approver groups required by the files changed in
+// a MR. As in CODEOWNERS, for every file the last matching rule wins; rules
+// defined in the config file are evaluated after the CODEOWNERS file ones.
+// Every rule matched by at least one file becomes a group named after its
+// pattern.
+func (ar ApprovRule) PathGroups(files []string) []ApproverGroup {
+	rules := append(append([]PathRule{}, ar.CodeOwners...), ar.Paths...)
+	matched := make([]bool, len(rules))
+	for _, f := range files {
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].Match(f) {
+				matched[i] = true
+				break
+			}
+		}
+	}
+	var groups []ApproverGroup
+	for i, r := range rules {
+		if !matched[i] {
+			continue
+		}
+		min := r.MinApprov
+		if min == 0 {
+			min = 1
+		}
+		groups = append(groups, ApproverGroup{Name: "path " + r.Pattern, Approvals: r.Approvals, MinApprov: min})
+	}
+	return groups
+}
+
+// HasPathRules reports if the approvals depend on the files changed by the MR.
+func (ar ApprovRule) HasPathRules() bool {
+	return len(ar.Paths) > 0 || len(ar.CodeOwners) > 0
+}
+
+// specificity ranks a branch pattern. Literal patterns always win over globs,
+// then the pattern with more literal characters is considered more specific.
+func specificity(pattern string) int {
+	literal := 0
+	escaped := false
+	inClass := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+			literal++
+		case c == '\\':
+			escaped = true
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '*' || c == '?':
+		default:
+			literal++
+		}
+	}
+	if !strings.ContainsAny(pattern, "*?[\\") {
+		// a glob matching the same branch never has more literal characters.
+		return literal + 1
+	}
+	return literal
+}
+
+// MatchBranch returns the rule that applies to MRs targeting branch.
+// When several branch rules match, the most specific pattern wins and ties
+// are resolved by the order in the config file. The project level
+// approvals/min_approv, if defined, are used when no branch rule matches.
+// ok is false when there is no rule at all for this branch. The project
+// level options (exclude_*, reset_on_push, block_*, require_pipeline_success)
+// are merged in the returned rule, as are the project and branch labels.
+func (ar ApprovRule) MatchBranch(branch string) (rule BranchRule, ok bool) {
+	best := -1
+	for _, br := range ar.Branches {
+		matched, err := path.Match(br.Pattern, branch)
+		if err != nil || !matched {
+			continue
+		}
+		if score := specificity(br.Pattern); score > best {
+			best = score
+			rule = br
+			ok = true
+		}
+	}
+	if !ok && (len(ar.Approvals) > 0 || len(ar.Groups) > 0) {
+		rule = BranchRule{Pattern: "*", Approvals: ar.Approvals, MinApprov: ar.MinApprov, Groups: ar.Groups}
+		ok = true
+	}
+	rule.ExcludeAuthor = rule.ExcludeAuthor || ar.ExcludeAuthor
+	rule.ExcludeCommitters = rule.ExcludeCommitters || ar.ExcludeCommitters
+	rule.ResetOnPush = rule.ResetOnPush || ar.ResetOnPush
+	rule.BlockDraft = rule.BlockDraft || ar.BlockDraft
+	rule.BlockConflicts = rule.BlockConflicts || ar.BlockConflicts
+	rule.BlockDiscussions = rule.BlockDiscussions || ar.BlockDiscussions
+	rule.RequirePipeline = rule.RequirePipeline || ar.RequirePipeline
+	rule.Labels = append(append([]LabelRule{}, ar.Labels...), rule.Labels...)
+	return rule, ok
+}
+
+// BlockUnmatched reports if MRs targeting a branch without rule must be blocked.
+func (ar ApprovRule) BlockUnmatched() bool {
+	return ar.DefaultAction == DefaultActionBlock
+}
+
+// FreezeWindow blocks every MR targeting a protected branch while it is
+// open, whatever the approvals. It is either recurring (Cron and Duration)
+// or absolute (Start and End, in freeze.DateLayout format), in Timezone.
+// Branches limits the freeze to the matching target branches, otherwise
+// the gitlab protected branches of the project are used. Projects limits
+// the freeze to those project ids, otherwise it applies to all projects.
+type FreezeWindow struct {
+	Name         string   `json:"name"                    validate:"required"`
+	Cron         string   `json:"cron,omitempty"          validate:"required_without=Start"`
+	Duration     string   `json:"duration,omitempty"      validate:"required_with=Cron"`
+	Start        string   `json:"start,omitempty"         validate:"required_without=Cron"`
+	End          string   `json:"end,omitempty"           validate:"required_with=Start"`
+	Timezone     string   `json:"timezone,omitempty"`
+	Branches     []string `json:"branches,omitempty"`
+	ExemptLabels []string `json:"exempt_labels,omitempty"`
+	Projects     []int    `json:"projects,omitempty"`
+	// Window is the schedule compiled when loading the config.
+	Window *freeze.Window `json:"-"`
+}
+
+// AppliesTo reports if the freeze concerns the project.
+func (f FreezeWindow) AppliesTo(project_id int) bool {
+	if len(f.Projects) == 0 {
+		return true
+	}
+	for _, p := range f.Projects {
+		if p == project_id {
+			return true
+		}
+	}
+	return false
+}
+
+// Exempts reports if one of labels exempts the MR from the freeze.
+func (f FreezeWindow) Exempts(labels []string) bool {
+	for _, e := range f.ExemptLabels {
+		for _, l := range labels {
+			if l == e {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type Config struct {
-	GitlabToken string       `json:"gitlab_token"            validate:"required,startswith=glpat-"`
-	GitlabURL   string       `json:"gitlab_url"              validate:"required,http_url"`
-	Projects    []ApprovRule `json:"projects"                validate:"required"`
-	PsqlConn    string       `json:"psql_conn_url"           validate:"required,startswith=postgres://"`
-	CorsOrigin  string       `json:"cors_origin"             validate:"required"`
-	WebHookToken string      `json:"webhook_token,omitempty" validate:"omitempty,gt=0"`
+	GitlabToken       string         `json:"gitlab_token"                   validate:"required,startswith=glpat-"`
+	GitlabURL         string         `json:"gitlab_url"                     validate:"required,http_url"`
+	Projects          []ApprovRule   `json:"projects"                       validate:"required,dive"`
+	PsqlConn          string         `json:"psql_conn_url,omitempty"        validate:"omitempty,startswith=postgres://"`
+	DBMaxOpenConns    int            `json:"db_max_open_conns,omitempty"    validate:"omitempty,gt=0"`
+	DBMaxIdleConns    int            `json:"db_max_idle_conns,omitempty"    validate:"omitempty,gt=0"`
+	DBConnMaxLifetime int            `json:"db_conn_max_lifetime,omitempty" validate:"omitempty,gt=0"`
+	DBIncompatible    string         `json:"db_incompatible,omitempty"      validate:"omitempty,oneof=read_only refuse"`
+	CorsOrigin        string         `json:"cors_origin"                    validate:"required"`
+	WebHookToken      string         `json:"webhook_token,omitempty"        validate:"omitempty,gt=0"`
+	GroupCacheTTL     int            `json:"group_cache_ttl,omitempty"      validate:"omitempty,gt=0"`
+	StateDir          string         `json:"state_dir,omitempty"            validate:"omitempty,dir"`
+	Freezes           []FreezeWindow `json:"freezes,omitempty"              validate:"omitempty,dive"`
+	Enforcer          string         `json:"enforcer,omitempty"             validate:"omitempty,oneof=db commit_status external_status_check"`
+	StatusCheck       string         `json:"status_check_url,omitempty"     validate:"omitempty,http_url"`
+	StatusCheckSecret string         `json:"status_check_secret,omitempty"  validate:"omitempty,min=16"`
+	CommitStatusName  string         `json:"commit_status_name,omitempty"   validate:"omitempty,max=255"`
+	SummaryNote       bool           `json:"summary_note,omitempty"`
+	ReconcileInterval int            `json:"reconcile_interval,omitempty"   validate:"omitempty,gt=0"`
+	ReconcileJitter   *int           `json:"reconcile_jitter,omitempty"     validate:"omitempty,gte=0"`
+	ReconcileWorkers  int            `json:"reconcile_workers,omitempty"    validate:"omitempty,gt=0"`
+	WebhookWorkers    int            `json:"webhook_workers,omitempty"      validate:"omitempty,gt=0"`
+	WebhookRetries    int            `json:"webhook_retries,omitempty"      validate:"omitempty,gt=0"`
+	WebhookDebounce   int            `json:"webhook_debounce,omitempty"     validate:"omitempty,gt=0"`
+	ReadyMaxBacklog   int            `json:"ready_max_backlog,omitempty"    validate:"omitempty,gt=0"`
+}
+
+// usesResetOnPush reports if reset_on_push is enabled at project level or in
+// any branch rule.
+func (ar ApprovRule) usesResetOnPush() bool {
+	if ar.ResetOnPush {
+		return true
+	}
+	for _, br := range ar.Branches {
+		if br.ResetOnPush {
+			return true
+		}
+	}
+	return false
+}
+
+// ProjectSummaryNote reports if the summary note is maintained on the
+// project MRs, enabled globally or at project level.
+func (c Config) ProjectSummaryNote(p ApprovRule) bool {
+	return c.SummaryNote || p.SummaryNote
+}
+
+// ProjectEnforcer returns the enforcer used for the project: the project
+// one, else the global one, else EnforcerDB.
+func (c Config) ProjectEnforcer(p ApprovRule) string {
+	if p.Enforcer != "" {
+		return p.Enforcer
+	}
+	if c.Enforcer != "" {
+		return c.Enforcer
+	}
+	return EnforcerDB
 }
 
-// NewDefaultConfig reads configuration from environment variables and validates it
+// NewConfig reads the configuration file and validates it. Every error is of
+// kind apperr.Config.
 func NewConfig(config_file string) (*Config, error) {
+	conf, err := loadConfig(config_file)
+	return conf, apperr.Wrap(apperr.Config, err)
+}
+
+func loadConfig(config_file string) (*Config, error) {
 	if config_file == "" {
 		config_file = "config.json"
 	}
@@ -41,7 +452,6 @@ func NewConfig(config_file string) (*Config, error) {
 	jsonFile, err := os.Open(config_file)
 	// if we os.Open returns an error then handle it
 	if err != nil {
-	  log.Fatal().AnErr("config", err)
 		return nil, errors.Wrap(err, "failed loading config file")
 	}
 
@@ -49,18 +459,66 @@ func NewConfig(config_file string) (*Config, error) {
 	defer jsonFile.Close()
 
 	// read our opened jsonFile as a byte array.
-  byteValue, _ := io.ReadAll(jsonFile)
+	byteValue, err := io.ReadAll(jsonFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed loading config file")
+	}
 
 	// we initialize our Users array
 	var conf Config
 	err = json.Unmarshal(byteValue, &conf)
 	if err != nil {
-		log.Fatal().Err(err).Send()
+		return nil, errors.Wrap(err, "failed parsing config file")
 	}
 	err = validate.New().Struct(conf)
 	if err != nil {
-		log.Fatal().Err(err).Send()
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	for _, p := range conf.Projects {
+		if conf.ProjectEnforcer(p) == EnforcerDB && conf.PsqlConn == "" {
+			return nil, errors.Errorf("project %d: psql_conn_url is required by the db enforcer", p.ProjectId)
+		}
+		if conf.ProjectEnforcer(p) == EnforcerStatusCheck && conf.StatusCheck == "" {
+			return nil, errors.Errorf("project %d: status_check_url is required by the external_status_check enforcer", p.ProjectId)
+		}
+		if conf.ProjectEnforcer(p) == EnforcerStatusCheck && conf.StatusCheckSecret == "" {
+			return nil, errors.Errorf("project %d: status_check_secret is required by the external_status_check enforcer", p.ProjectId)
+		}
+		if p.usesResetOnPush() && conf.StateDir == "" {
+			return nil, errors.Errorf("project %d: state_dir is required by reset_on_push", p.ProjectId)
+		}
+		if p.Reviewers != nil && p.Reviewers.Strategy == StrategyRoundRobin && conf.StateDir == "" {
+			return nil, errors.Errorf("project %d: state_dir is required by the round_robin reviewer strategy", p.ProjectId)
+		}
+	}
+	for i, f := range conf.Freezes {
+		w, err := freeze.New(f.Cron, f.Duration, f.Start, f.End, f.Timezone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid freeze window '%s'", f.Name)
+		}
+		conf.Freezes[i].Window = w
 	}
-  
+	for i, p := range conf.Projects {
+		for _, br := range p.Branches {
+			if _, err := path.Match(br.Pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "project %d: invalid branch pattern '%s'", p.ProjectId, br.Pattern)
+			}
+		}
+		for j, pr := range p.Paths {
+			re, err := codeowners.Compile(pr.Pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "project %d: invalid path pattern '%s'", p.ProjectId, pr.Pattern)
+			}
+			conf.Projects[i].Paths[j].Regexp = re
+		}
+		if p.CodeOwnersFile != "" {
+			rules, err := codeowners.ParseFile(p.CodeOwnersFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "project %d: invalid codeowners file '%s'", p.ProjectId, p.CodeOwnersFile)
+			}
+			conf.Projects[i].CodeOwners = codeOwnersRules(rules)
+		}
+	}
+
 	return &conf, nil
 }
@@ -11,6 +11,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/cropalato/MergeSentinel/internal/codeowners"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,11 +61,199 @@ func TestNewConfig(t *testing.T) {
 		assert.Equal(t, "https://example.com", conf.CorsOrigin, "Unexpected CorsOrigin")
 	})
 
+	// Test compiling the path rule patterns once, when loading the config
+	t.Run("Path rules", func(t *testing.T) {
+		content := `{"gitlab_token": "glpat-token", "gitlab_url": "https://gitlab.com", "enforcer": "commit_status", "projects": [{"project_id": 1, "approvals": ["user1"], "min_approv": 1, "paths": [{"pattern": "db/", "approvals": ["dba"]}]}], "cors_origin": "*"}`
+		if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test config file: %v", err)
+		}
+		conf, err := NewConfig(configFile)
+		assert.NoError(t, err)
+		assert.NotNil(t, conf.Projects[0].Paths[0].Regexp, "Expected the path pattern compiled")
+		assert.True(t, conf.Projects[0].Paths[0].Match("db/schema.sql"))
+		assert.False(t, conf.Projects[0].Paths[0].Match("api/db.go"))
+	})
+
+	// Test disabling the reconcile jitter, distinct from leaving it unset
+	t.Run("Reconcile jitter", func(t *testing.T) {
+		for content, jitter := range map[string]*int{
+			`{"gitlab_token": "glpat-token", "gitlab_url": "https://gitlab.com", "enforcer": "commit_status", "projects": [{"project_id": 1, "approvals": ["user1"], "min_approv": 1}], "cors_origin": "*", "reconcile_jitter": 0}`: new(int),
+			`{"gitlab_token": "glpat-token", "gitlab_url": "https://gitlab.com", "enforcer": "commit_status", "projects": [{"project_id": 1, "approvals": ["user1"], "min_approv": 1}], "cors_origin": "*"}`:                        nil,
+		} {
+			if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+			conf, err := NewConfig(configFile)
+			assert.NoError(t, err)
+			assert.Equal(t, jitter, conf.ReconcileJitter)
+		}
+	})
+
 	// Test loading a non-existent configuration file
 	t.Run("Non-existent config file", func(t *testing.T) {
 		_, err := NewConfig("non_existent_file.json")
 		assert.Error(t, err, "Expected error when loading a non-existent config file")
+		assert.ErrorIs(t, err, apperr.ErrConfig)
+	})
+
+	// Test loading invalid configuration files, returning errors instead of exiting
+	t.Run("Invalid config file", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"json":                `{"gitlab_token": `,
+			"validate":            `{"gitlab_token": "token", "gitlab_url": "https://gitlab.com", "projects": [], "cors_origin": "*"}`,
+			"psql":                `{"gitlab_token": "glpat-token", "gitlab_url": "https://gitlab.com", "projects": [{"project_id": 1, "approvals": ["user1"], "min_approv": 1}], "cors_origin": "*"}`,
+			"status_check_secret": `{"gitlab_token": "glpat-token", "gitlab_url": "https://gitlab.com", "enforcer": "external_status_check", "status_check_url": "https://sentinel.example.com/api/v1/status_check", "projects": [{"project_id": 1, "approvals": ["user1"], "min_approv": 1}], "cors_origin": "*"}`,
+			"round_robin":         `{"gitlab_token": "glpat-token", "gitlab_url": "https://gitlab.com", "enforcer": "commit_status", "projects": [{"project_id": 1, "approvals": ["user1"], "min_approv": 1, "assign_reviewers": {"strategy": "round_robin"}}], "cors_origin": "*"}`,
+			"reset_on_push":       `{"gitlab_token": "glpat-token", "gitlab_url": "https://gitlab.com", "enforcer": "commit_status", "projects": [{"project_id": 1, "branches": [{"pattern": "main", "approvals": ["user1"], "min_approv": 1, "reset_on_push": true}]}], "cors_origin": "*"}`,
+		} {
+			if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+			conf, err := NewConfig(configFile)
+			assert.Nil(t, conf, name)
+			assert.ErrorIs(t, err, apperr.ErrConfig, name)
+		}
 	})
 }
 
+// TestMatchBranch tests the selection of the branch rule for a target branch.
+func TestMatchBranch(t *testing.T) {
+	ar := ApprovRule{
+		ProjectId: 1,
+		Branches: []BranchRule{
+			{Pattern: "*", Approvals: []string{"any"}, MinApprov: 1},
+			{Pattern: "release/*", Approvals: []string{"rel"}, MinApprov: 2},
+			{Pattern: "release/v1.*", Approvals: []string{"v1"}, MinApprov: 2},
+			{Pattern: "main", Approvals: []string{"owner"}, MinApprov: 3},
+		},
+	}
+
+	t.Run("Literal pattern wins", func(t *testing.T) {
+		rule, ok := ar.MatchBranch("main")
+		assert.True(t, ok)
+		assert.Equal(t, "main", rule.Pattern)
+	})
+
+	t.Run("Most specific glob wins", func(t *testing.T) {
+		rule, ok := ar.MatchBranch("release/v1.2")
+		assert.True(t, ok)
+		assert.Equal(t, "release/v1.*", rule.Pattern)
+		rule, ok = ar.MatchBranch("release/v2.0")
+		assert.True(t, ok)
+		assert.Equal(t, "release/*", rule.Pattern)
+	})
+
+	t.Run("Catch-all glob", func(t *testing.T) {
+		rule, ok := ar.MatchBranch("feature")
+		assert.True(t, ok)
+		assert.Equal(t, "*", rule.Pattern)
+	})
+
+	t.Run("No matching rule", func(t *testing.T) {
+		ar := ApprovRule{ProjectId: 1, Branches: []BranchRule{{Pattern: "main", Approvals: []string{"a"}, MinApprov: 1}}}
+		_, ok := ar.MatchBranch("feature/x")
+		assert.False(t, ok)
+		assert.False(t, ar.BlockUnmatched())
+		ar.DefaultAction = DefaultActionBlock
+		assert.True(t, ar.BlockUnmatched())
+	})
 
+	t.Run("Project level rule as fallback", func(t *testing.T) {
+		ar := ApprovRule{
+			ProjectId: 1,
+			Approvals: []string{"user1"},
+			MinApprov: 1,
+			Branches:  []BranchRule{{Pattern: "main", Approvals: []string{"a"}, MinApprov: 2}},
+		}
+		rule, ok := ar.MatchBranch("feature/x")
+		assert.True(t, ok)
+		assert.Equal(t, []string{"user1"}, rule.Approvals)
+		assert.Equal(t, 1, rule.MinApprov)
+	})
+}
+
+// TestApproverGroups tests the groups returned for a branch rule.
+func TestApproverGroups(t *testing.T) {
+	br := BranchRule{
+		Pattern:   "main",
+		Approvals: []string{"user1"},
+		MinApprov: 1,
+		Groups: []ApproverGroup{
+			{Name: "backend", Approvals: []string{"a", "b"}, MinApprov: 2},
+			{Name: "security", Approvals: []string{"c"}, MinApprov: 1},
+		},
+	}
+	groups := br.ApproverGroups()
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "", groups[0].Name)
+	assert.Equal(t, []string{"user1"}, groups[0].Approvals)
+	assert.Equal(t, "backend", groups[1].Name)
+	assert.Equal(t, "security", groups[2].Name)
+
+	br = BranchRule{Pattern: "main", Groups: br.Groups}
+	assert.Len(t, br.ApproverGroups(), 2)
+}
+
+// TestPathGroups tests the approver groups required by the changed files.
+func TestPathGroups(t *testing.T) {
+	ar := ApprovRule{
+		ProjectId: 1,
+		CodeOwners: codeOwnersRules([]codeowners.Rule{
+			{Pattern: "*.md", Owners: []string{"@writer", "doc@example.com"}},
+			{Pattern: "db/", Owners: []string{"@org/dba"}},
+		}),
+		Paths: []PathRule{
+			{Pattern: "db/migrations/", Approvals: []string{"dba1", "dba2"}, MinApprov: 2},
+		},
+	}
+	assert.True(t, ar.HasPathRules())
+	assert.Equal(t, []string{"writer"}, ar.CodeOwners[0].Approvals)
+	assert.Equal(t, []string{"@org/dba"}, ar.CodeOwners[1].Approvals)
+
+	t.Run("No matching file", func(t *testing.T) {
+		assert.Empty(t, ar.PathGroups([]string{"main.go"}))
+	})
+
+	t.Run("Last matching rule wins", func(t *testing.T) {
+		groups := ar.PathGroups([]string{"db/migrations/001.sql"})
+		assert.Equal(t, []ApproverGroup{{Name: "path db/migrations/", Approvals: []string{"dba1", "dba2"}, MinApprov: 2}}, groups)
+	})
+
+	t.Run("Union of matching rules", func(t *testing.T) {
+		groups := ar.PathGroups([]string{"README.md", "db/schema.sql", "db/migrations/001.sql"})
+		assert.Len(t, groups, 3)
+		assert.Equal(t, "path *.md", groups[0].Name)
+		assert.Equal(t, 1, groups[0].MinApprov)
+		assert.Equal(t, "path db/", groups[1].Name)
+		assert.Equal(t, "path db/migrations/", groups[2].Name)
+	})
+}
+
+// TestMatchBranchExclusions tests the merge of the project exclude options.
+func TestMatchBranchExclusions(t *testing.T) {
+	ar := ApprovRule{
+		ProjectId:     1,
+		Approvals:     []string{"user1"},
+		MinApprov:     1,
+		ExcludeAuthor: true,
+		Branches: []BranchRule{
+			{Pattern: "main", Approvals: []string{"a"}, MinApprov: 1, ExcludeCommitters: true},
+		},
+	}
+	rule, _ := ar.MatchBranch("main")
+	assert.True(t, rule.ExcludeAuthor)
+	assert.True(t, rule.ExcludeCommitters)
+
+	rule, _ = ar.MatchBranch("feature")
+	assert.True(t, rule.ExcludeAuthor)
+	assert.False(t, rule.ExcludeCommitters)
+}
+
+// TestProjectEnforcer tests the enforcer selection.
+func TestProjectEnforcer(t *testing.T) {
+	c := Config{}
+	assert.Equal(t, EnforcerDB, c.ProjectEnforcer(ApprovRule{}))
+	c.Enforcer = EnforcerCommitStatus
+	assert.Equal(t, EnforcerCommitStatus, c.ProjectEnforcer(ApprovRule{}))
+	assert.Equal(t, EnforcerDB, c.ProjectEnforcer(ApprovRule{Enforcer: EnforcerDB}))
+}
@@ -0,0 +1,177 @@
+//
+// freeze.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package freeze computes when merge freeze windows are active. A window is
+// either recurring, defined by a cron expression and a duration, or an
+// absolute date range.
+package freeze
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DateLayout is the layout of the absolute window boundaries, read in the
+// window timezone.
+const DateLayout = "2006-01-02 15:04"
+
+// maxDuration limits recurring windows, bounding the search for the last
+// window start.
+const maxDuration = 31 * 24 * time.Hour
+
+// Window is a merge freeze window.
+type Window struct {
+	cron     *cron
+	duration time.Duration
+	start    time.Time
+	end      time.Time
+	location *time.Location
+}
+
+// New creates a window. When cronExpr is not empty the window is recurring:
+// it opens at every time matching cronExpr and stays open for duration.
+// Otherwise it is open between start and end, in DateLayout format.
+// timezone is an IANA name, UTC when empty.
+func New(cronExpr string, duration string, start string, end string, timezone string) (*Window, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid timezone '%s'", timezone)
+	}
+	w := &Window{location: loc}
+	if cronExpr != "" {
+		if w.cron, err = parseCron(cronExpr); err != nil {
+			return nil, err
+		}
+		if w.duration, err = time.ParseDuration(duration); err != nil {
+			return nil, errors.Wrapf(err, "invalid duration '%s'", duration)
+		}
+		if w.duration <= 0 || w.duration > maxDuration {
+			return nil, errors.Errorf("duration '%s' must be positive and at most %s", duration, maxDuration)
+		}
+		return w, nil
+	}
+	if w.start, err = time.ParseInLocation(DateLayout, start, loc); err != nil {
+		return nil, errors.Wrapf(err, "invalid start '%s'", start)
+	}
+	if w.end, err = time.ParseInLocation(DateLayout, end, loc); err != nil {
+		return nil, errors.Wrapf(err, "invalid end '%s'", end)
+	}
+	if !w.end.After(w.start) {
+		return nil, errors.Errorf("end '%s' must be after start '%s'", end, start)
+	}
+	return w, nil
+}
+
+// ActiveUntil reports if the window is open at t and, if so, when it closes.
+func (w *Window) ActiveUntil(t time.Time) (time.Time, bool) {
+	if w.cron == nil {
+		if !t.Before(w.start) && t.Before(w.end) {
+			return w.end, true
+		}
+		return time.Time{}, false
+	}
+	// look for the most recent window start still open at t
+	t = t.In(w.location)
+	m := t.Truncate(time.Minute)
+	for m.After(t.Add(-w.duration)) {
+		if w.cron.match(m) {
+			return m.Add(w.duration), true
+		}
+		m = m.Add(-time.Minute)
+	}
+	return time.Time{}, false
+}
+
+// Active reports if the window is open at t.
+func (w *Window) Active(t time.Time) bool {
+	_, ok := w.ActiveUntil(t)
+	return ok
+}
+
+// cron is a parsed standard 5 fields cron expression:
+// minute hour day-of-month month day-of-week.
+type cron struct {
+	minute, hour, dom, month, dow map[int]bool
+	domStar, dowStar              bool
+}
+
+func parseCron(expr string) (*cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression '%s' must have 5 fields", expr)
+	}
+	c := &cron{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := []*map[int]bool{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		if *sets[i], err = parseField(f, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, errors.Wrapf(err, "cron expression '%s'", expr)
+		}
+	}
+	// 7 is also sunday
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	return c, nil
+}
+
+// parseField parses a cron field: "*", "N", "N-M", with an optional "/step",
+// or a comma separated list of those.
+func parseField(field string, min int, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, errors.Errorf("invalid step in '%s'", part)
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.Errorf("invalid value '%s'", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.Errorf("invalid value '%s'", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, errors.Errorf("value '%s' out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (c *cron) match(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	// as in cron, when both day fields are restricted either one can match
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
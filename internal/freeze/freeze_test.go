@@ -0,0 +1,77 @@
+//
+// freeze_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package freeze
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRecurringWindow tests a weekend freeze defined by a cron expression.
+func TestRecurringWindow(t *testing.T) {
+	// from friday 18:00 to monday 08:00, Montreal time
+	w, err := New("0 18 * * 5", "62h", "", "", "America/Montreal")
+	assert.NoError(t, err)
+	loc, _ := time.LoadLocation("America/Montreal")
+
+	friday := time.Date(2024, 8, 2, 18, 0, 0, 0, loc)
+	until, ok := w.ActiveUntil(friday)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 8, 5, 8, 0, 0, 0, loc), until.In(loc))
+
+	assert.False(t, w.Active(friday.Add(-time.Minute)))
+	assert.True(t, w.Active(time.Date(2024, 8, 4, 12, 0, 0, 0, loc)))
+	assert.True(t, w.Active(time.Date(2024, 8, 5, 7, 59, 0, 0, loc)))
+	assert.False(t, w.Active(time.Date(2024, 8, 5, 8, 0, 0, 0, loc)))
+	// same instant expressed in UTC
+	assert.True(t, w.Active(time.Date(2024, 8, 3, 3, 0, 0, 0, time.UTC)))
+}
+
+// TestAbsoluteWindow tests a freeze defined by a date range.
+func TestAbsoluteWindow(t *testing.T) {
+	w, err := New("", "", "2024-12-20 17:00", "2025-01-06 09:00", "")
+	assert.NoError(t, err)
+	assert.False(t, w.Active(time.Date(2024, 12, 20, 16, 59, 0, 0, time.UTC)))
+	assert.True(t, w.Active(time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, w.Active(time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)))
+}
+
+// TestInvalidWindow tests the validation of the window definition.
+func TestInvalidWindow(t *testing.T) {
+	for _, c := range [][5]string{
+		{"0 18 * *", "1h", "", "", ""},
+		{"61 18 * * *", "1h", "", "", ""},
+		{"0 18 * * 5", "", "", "", ""},
+		{"0 18 * * 5", "1000h", "", "", ""},
+		{"0 18 * * 5", "1h", "", "", "Nowhere/City"},
+		{"", "", "2024-12-20", "2025-01-06 09:00", ""},
+		{"", "", "2025-01-06 09:00", "2024-12-20 17:00", ""},
+	} {
+		_, err := New(c[0], c[1], c[2], c[3], c[4])
+		assert.Error(t, err, "window %v", c)
+	}
+}
+
+// TestParseField tests cron fields syntax.
+func TestParseField(t *testing.T) {
+	values, err := parseField("1-5,10,*/20", 0, 59)
+	assert.NoError(t, err)
+	for _, v := range []int{0, 1, 5, 10, 20, 40} {
+		assert.True(t, values[v], "value %d", v)
+	}
+	assert.False(t, values[6])
+
+	c, err := parseCron("0 0 1 * 1")
+	assert.NoError(t, err)
+	// first day of the month or mondays
+	assert.True(t, c.match(time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, c.match(time.Date(2024, 8, 5, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, c.match(time.Date(2024, 8, 6, 0, 0, 0, 0, time.UTC)))
+}
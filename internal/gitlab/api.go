@@ -0,0 +1,173 @@
+//
+// api.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// MergeRequest returns the merge request iid of the project.
+func (c *Client) MergeRequest(ctx context.Context, project_id int, iid int) (MergeRequest, error) {
+	var mr MergeRequest
+	err := c.Get(ctx, fmt.Sprintf("projects/%d/merge_requests/%d", project_id, iid), &mr)
+	return mr, err
+}
+
+// MergeRequests returns the merge requests of the project matching the
+// query, e.g. state=opened.
+func (c *Client) MergeRequests(ctx context.Context, project_id int, query url.Values) ([]MergeRequest, error) {
+	return ListAll[MergeRequest](ctx, c, fmt.Sprintf("projects/%d/merge_requests", project_id), query)
+}
+
+// CountMergeRequests returns how many merge requests, of every project,
+// match the query.
+func (c *Client) CountMergeRequests(ctx context.Context, query url.Values) (int, error) {
+	return Count[MergeRequest](ctx, c, "merge_requests", query)
+}
+
+// SetReviewers replaces the reviewers of the merge request.
+func (c *Client) SetReviewers(ctx context.Context, project_id int, iid int, user_ids []int) error {
+	_, err := c.Do(ctx, http.MethodPut, fmt.Sprintf("projects/%d/merge_requests/%d", project_id, iid), map[string][]int{"reviewer_ids": user_ids}, nil)
+	return err
+}
+
+// Approvals returns the approval state of the merge request.
+func (c *Client) Approvals(ctx context.Context, project_id int, iid int) (Approvals, error) {
+	var approvals Approvals
+	err := c.Get(ctx, fmt.Sprintf("projects/%d/merge_requests/%d/approvals", project_id, iid), &approvals)
+	return approvals, err
+}
+
+// Commits returns the commits of the merge request.
+func (c *Client) Commits(ctx context.Context, project_id int, iid int) ([]Commit, error) {
+	return ListAll[Commit](ctx, c, fmt.Sprintf("projects/%d/merge_requests/%d/commits", project_id, iid), nil)
+}
+
+// Diffs returns the files changed by the merge request.
+func (c *Client) Diffs(ctx context.Context, project_id int, iid int) ([]Diff, error) {
+	return ListAll[Diff](ctx, c, fmt.Sprintf("projects/%d/merge_requests/%d/diffs", project_id, iid), nil)
+}
+
+// LabelEvents returns the label events of the merge request.
+func (c *Client) LabelEvents(ctx context.Context, project_id int, iid int) ([]LabelEvent, error) {
+	return ListAll[LabelEvent](ctx, c, fmt.Sprintf("projects/%d/merge_requests/%d/resource_label_events", project_id, iid), nil)
+}
+
+// Notes returns the notes of the merge request, oldest first.
+func (c *Client) Notes(ctx context.Context, project_id int, iid int) ([]Note, error) {
+	return ListAll[Note](ctx, c, fmt.Sprintf("projects/%d/merge_requests/%d/notes", project_id, iid), url.Values{"sort": {"asc"}})
+}
+
+// CreateNote adds a note to the merge request.
+func (c *Client) CreateNote(ctx context.Context, project_id int, iid int, body string) (Note, error) {
+	var note Note
+	_, err := c.Do(ctx, http.MethodPost, fmt.Sprintf("projects/%d/merge_requests/%d/notes", project_id, iid), map[string]string{"body": body}, &note)
+	return note, err
+}
+
+// UpdateNote replaces the body of a note of the merge request.
+func (c *Client) UpdateNote(ctx context.Context, project_id int, iid int, note_id int, body string) (Note, error) {
+	var note Note
+	_, err := c.Do(ctx, http.MethodPut, fmt.Sprintf("projects/%d/merge_requests/%d/notes/%d", project_id, iid, note_id), map[string]string{"body": body}, &note)
+	return note, err
+}
+
+// CurrentUser returns the user of the token.
+func (c *Client) CurrentUser(ctx context.Context) (User, error) {
+	var u User
+	err := c.Get(ctx, "user", &u)
+	return u, err
+}
+
+// Users returns the users matching the query, e.g. username or search.
+func (c *Client) Users(ctx context.Context, query url.Values) ([]User, error) {
+	return ListAll[User](ctx, c, "users", query)
+}
+
+// GroupMembers returns the members of the group, including the inherited
+// ones. group is the id or the full path of the group.
+func (c *Client) GroupMembers(ctx context.Context, group string) ([]Member, error) {
+	return ListAll[Member](ctx, c, fmt.Sprintf("groups/%s/members/all", url.PathEscape(group)), nil)
+}
+
+// ProtectedBranches returns the protected branches of the project.
+func (c *Client) ProtectedBranches(ctx context.Context, project_id int) ([]ProtectedBranch, error) {
+	return ListAll[ProtectedBranch](ctx, c, fmt.Sprintf("projects/%d/protected_branches", project_id), nil)
+}
+
+// CommitStatusOptions describes a commit status.
+type CommitStatusOptions struct {
+	State       string `json:"state"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+// SetCommitStatus posts a status on the commit sha of the project.
+func (c *Client) SetCommitStatus(ctx context.Context, project_id int, sha string, opts CommitStatusOptions) error {
+	_, err := c.Do(ctx, http.MethodPost, fmt.Sprintf("projects/%d/statuses/%s", project_id, sha), opts, nil)
+	return err
+}
+
+// CommitStatuses returns the latest status named name of the commit sha.
+func (c *Client) CommitStatuses(ctx context.Context, project_id int, sha string, name string) ([]CommitStatus, error) {
+	return ListAll[CommitStatus](ctx, c, fmt.Sprintf("projects/%d/repository/commits/%s/statuses", project_id, sha), url.Values{"name": {name}})
+}
+
+// ExternalStatusChecks returns the external status checks of the project.
+func (c *Client) ExternalStatusChecks(ctx context.Context, project_id int) ([]ExternalStatusCheck, error) {
+	return ListAll[ExternalStatusCheck](ctx, c, fmt.Sprintf("projects/%d/external_status_checks", project_id), nil)
+}
+
+// CreateExternalStatusCheck adds an external status check, calling
+// external_url, to the project. The calls are signed with shared_secret,
+// if not empty.
+func (c *Client) CreateExternalStatusCheck(ctx context.Context, project_id int, name string, external_url string, shared_secret string) (ExternalStatusCheck, error) {
+	var check ExternalStatusCheck
+	payload := map[string]string{"name": name, "external_url": external_url}
+	if shared_secret != "" {
+		payload["shared_secret"] = shared_secret
+	}
+	_, err := c.Do(ctx, http.MethodPost, fmt.Sprintf("projects/%d/external_status_checks", project_id), payload, &check)
+	return check, err
+}
+
+// SetExternalStatusCheckSecret sets the shared secret signing the calls of
+// the project external status check check_id.
+func (c *Client) SetExternalStatusCheckSecret(ctx context.Context, project_id int, check_id int, shared_secret string) error {
+	payload := map[string]string{"shared_secret": shared_secret}
+	_, err := c.Do(ctx, http.MethodPut, fmt.Sprintf("projects/%d/external_status_checks/%d", project_id, check_id), payload, nil)
+	return err
+}
+
+// StatusChecks returns the external status checks of the merge request.
+func (c *Client) StatusChecks(ctx context.Context, project_id int, iid int) ([]StatusCheck, error) {
+	return ListAll[StatusCheck](ctx, c, fmt.Sprintf("projects/%d/merge_requests/%d/status_checks", project_id, iid), nil)
+}
+
+// SetStatusCheckResponse answers the external status check check_id for the
+// commit sha of the merge request, with status passed or failed.
+func (c *Client) SetStatusCheckResponse(ctx context.Context, project_id int, iid int, sha string, check_id int, status string) error {
+	response := map[string]interface{}{
+		"sha":                      sha,
+		"external_status_check_id": check_id,
+		"status":                   status,
+	}
+	_, err := c.Do(ctx, http.MethodPost, fmt.Sprintf("projects/%d/merge_requests/%d/status_check_responses", project_id, iid), response, nil)
+	return err
+}
+
+// Version returns the version of the gitlab instance, e.g. 17.2.1-ee.
+func (c *Client) Version(ctx context.Context) (Version, error) {
+	var v Version
+	err := c.Get(ctx, "version", &v)
+	return v, err
+}
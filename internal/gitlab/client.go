@@ -0,0 +1,379 @@
+//
+// client.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package gitlab is a client of the gitlab REST API v4. Requests are retried
+// with exponential backoff on 5xx and 429 replies, following the
+// Retry-After and RateLimit-* headers, and every attempt has its own
+// deadline.
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// perPage is the page size used when listing objects.
+	perPage = 100
+	// DefaultTimeout is the deadline of every request attempt.
+	DefaultTimeout = 10 * time.Second
+	// DefaultRetries is how many times a failed request is retried.
+	DefaultRetries = 4
+	// minBackoff and maxBackoff bound the delay between two attempts.
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+	// maxRetryAfter bounds the delay asked by gitlab.
+	maxRetryAfter = 2 * time.Minute
+)
+
+// Client calls the gitlab API.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	// Timeout is the deadline of every request attempt.
+	Timeout time.Duration
+	// Retries is how many times a request is retried on 429 and 5xx
+	// replies, or on network errors.
+	Retries int
+	// Backoff is the delay before the first retry, doubled on each retry.
+	Backoff time.Duration
+
+	mu sync.Mutex
+	// throttledUntil is when the rate limit resets, once exhausted.
+	throttledUntil time.Time
+}
+
+// New creates a client of the gitlab instance at baseURL, authenticated with
+// token.
+func New(baseURL string, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/") + "/api/v4/",
+		token:      token,
+		httpClient: httpClient,
+		Timeout:    DefaultTimeout,
+		Retries:    DefaultRetries,
+		Backoff:    minBackoff,
+	}
+}
+
+// apiPath appends the encoded query, if any, to the API endpoint path.
+func apiPath(path string, query url.Values) string {
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// url returns the absolute URL of the API endpoint. endpoint can also be an
+// absolute URL, e.g. from a Link header, if it is on the gitlab API.
+func (c *Client) url(endpoint string) (string, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return c.baseURL + strings.TrimLeft(endpoint, "/"), nil
+	}
+	// never send the token to another host
+	if !strings.HasPrefix(endpoint, c.baseURL) {
+		return "", fmt.Errorf("refusing to call '%s', outside of the gitlab API", endpoint)
+	}
+	return endpoint, nil
+}
+
+// idempotent reports if a request with method can be sent again after a
+// failure whose effect is unknown.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the retry number attempt (0 based), with
+// full jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.Backoff << attempt
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// retryAfter returns the delay asked by the Retry-After header, in seconds
+// or as a date.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if s, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
+
+// throttle records when the rate limit resets, once no request is left.
+func (c *Client) throttle(header http.Header) {
+	if header.Get("RateLimit-Remaining") != "0" {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	until := time.Unix(reset, 0)
+	if until.Sub(time.Now()) > maxRetryAfter {
+		until = time.Now().Add(maxRetryAfter)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until.After(c.throttledUntil) {
+		c.throttledUntil = until
+	}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// waitRateLimit waits until the rate limit resets, if it is exhausted.
+func (c *Client) waitRateLimit(ctx context.Context) error {
+	c.mu.Lock()
+	wait := time.Until(c.throttledUntil)
+	c.mu.Unlock()
+	if wait > 0 {
+		log.Debug().Dur("wait", wait).Msg("gitlab rate limit reached")
+	}
+	return sleep(ctx, wait)
+}
+
+// Do calls the API endpoint (relative to /api/v4) with method, sending
+// payload, if not nil, as json. The json reply is decoded in v, if not nil.
+// It returns the reply headers, or an *Error when gitlab replies with an
+// error status. Every error is of kind apperr.Upstream.
+func (c *Client) Do(ctx context.Context, method string, endpoint string, payload interface{}, v interface{}) (http.Header, error) {
+	header, err := c.do(ctx, method, endpoint, payload, v)
+	return header, apperr.Wrap(apperr.Upstream, err)
+}
+
+func (c *Client) do(ctx context.Context, method string, endpoint string, payload interface{}, v interface{}) (http.Header, error) {
+	target, err := c.url(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var content []byte
+	if payload != nil {
+		if content, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.waitRateLimit(ctx); err != nil {
+			return nil, err
+		}
+		header, reply, err := c.send(ctx, method, target, content)
+		if err == nil {
+			if v == nil || len(reply) == 0 {
+				return header, nil
+			}
+			return header, json.Unmarshal(reply, v)
+		}
+		delay, retry := c.retryDelay(method, header, err, attempt)
+		if !retry || attempt >= c.Retries {
+			return header, err
+		}
+		log.Debug().Err(err).Str("method", method).Str("endpoint", endpoint).Dur("delay", delay).Int("attempt", attempt+1).Msg("retrying gitlab request")
+		if err := sleep(ctx, delay); err != nil {
+			return header, err
+		}
+	}
+}
+
+// retryDelay reports if the failed attempt can be retried, and after which
+// delay.
+func (c *Client) retryDelay(method string, header http.Header, err error, attempt int) (time.Duration, bool) {
+	apiErr, ok := err.(*Error)
+	switch {
+	case ok && apiErr.StatusCode == http.StatusTooManyRequests:
+		// the request was not processed
+	case ok && apiErr.StatusCode >= 500 && idempotent(method):
+	case !ok && idempotent(method):
+		// network error or attempt deadline
+	default:
+		return 0, false
+	}
+	if d, ok := retryAfter(header, time.Now()); ok {
+		return d, true
+	}
+	return c.backoff(attempt), true
+}
+
+// send makes one attempt of the request, with its own deadline.
+func (c *Client) send(ctx context.Context, method string, target string, content []byte) (http.Header, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	var body io.Reader
+	if content != nil {
+		body = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("PRIVATE-TOKEN", c.token)
+	if content != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	log.Debug().Str("method", method).Str("url", req.URL.String()).Msg("calling gitlab")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	c.throttle(resp.Header)
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.Header, nil, newError(method, req.URL.Path, resp, reply)
+	}
+	return resp.Header, reply, nil
+}
+
+// Get calls the API endpoint and decodes the json reply in v.
+func (c *Client) Get(ctx context.Context, endpoint string, v interface{}) error {
+	_, err := c.Do(ctx, http.MethodGet, endpoint, nil, v)
+	return err
+}
+
+// nextLink returns the URL of the rel="next" entry of a Link header.
+func nextLink(link string) string {
+	for _, entry := range strings.Split(link, ",") {
+		parts := strings.Split(entry, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.ReplaceAll(strings.TrimSpace(param), " ", "") == `rel="next"` {
+				return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+			}
+		}
+	}
+	return ""
+}
+
+// linkEndpoint returns the API endpoint, with its query, of a URL built by
+// gitlab, e.g. in a Link header. gitlab builds them on its external_url, not
+// necessarily the address it is reached on, so only the part after /api/v4/
+// is kept and called on the configured base URL.
+func linkEndpoint(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", false
+	}
+	path := u.EscapedPath()
+	i := strings.Index(path, "/api/v4/")
+	if i < 0 {
+		return "", false
+	}
+	endpoint := path[i+len("/api/v4/"):]
+	if u.RawQuery != "" {
+		endpoint += "?" + u.RawQuery
+	}
+	return endpoint, true
+}
+
+// ListAll calls a list endpoint with the query and loads every page. It
+// follows the Link header, used by both offset and keyset pagination (query
+// "pagination=keyset"), or else the X-Next-Page header.
+func ListAll[T any](ctx context.Context, c *Client, path string, query url.Values) ([]T, error) {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("per_page", strconv.Itoa(perPage))
+	endpoint := apiPath(path, q)
+	var all []T
+	for endpoint != "" {
+		var items []T
+		header, err := c.Do(ctx, http.MethodGet, endpoint, nil, &items)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if next, ok := linkEndpoint(nextLink(header.Get("Link"))); ok {
+			endpoint = next
+			continue
+		}
+		endpoint = ""
+		if page := header.Get("X-Next-Page"); page != "" {
+			q.Set("page", page)
+			endpoint = apiPath(path, q)
+		}
+	}
+	return all, nil
+}
+
+// Count returns how many objects the list endpoint returns with the query.
+func Count[T any](ctx context.Context, c *Client, path string, query url.Values) (int, error) {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("per_page", "1")
+	var items []T
+	header, err := c.Do(ctx, http.MethodGet, apiPath(path, q), nil, &items)
+	if err != nil {
+		return 0, err
+	}
+	if total, err := strconv.Atoi(header.Get("X-Total")); err == nil {
+		return total, nil
+	}
+	// gitlab omits X-Total on large collections
+	all, err := ListAll[T](ctx, c, path, query)
+	return len(all), err
+}
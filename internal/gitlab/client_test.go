@@ -0,0 +1,197 @@
+//
+// client_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient creates a client of srv retrying without delay.
+func newTestClient(srv *httptest.Server) *Client {
+	c := New(srv.URL, "glpat-token", srv.Client())
+	c.Backoff = time.Millisecond
+	return c
+}
+
+// TestNextLink tests the parsing of the Link header.
+func TestNextLink(t *testing.T) {
+	link := `<https://gitlab.example.com/api/v4/projects?id_after=42&per_page=100>; rel="next", <https://gitlab.example.com/api/v4/projects?page=1>; rel="first"`
+	assert.Equal(t, "https://gitlab.example.com/api/v4/projects?id_after=42&per_page=100", nextLink(link))
+	assert.Equal(t, "", nextLink(`<https://gitlab.example.com/api/v4/projects?page=1>; rel="first"`))
+	assert.Equal(t, "", nextLink(""))
+}
+
+// TestLinkEndpoint tests that the links built on another gitlab address are
+// rewritten onto the API endpoint.
+func TestLinkEndpoint(t *testing.T) {
+	endpoint, ok := linkEndpoint("https://gitlab.example.com/api/v4/projects/group%2Fname/members?id_after=42")
+	assert.True(t, ok)
+	assert.Equal(t, "projects/group%2Fname/members?id_after=42", endpoint)
+	endpoint, ok = linkEndpoint("http://gitlab.internal/gitlab/api/v4/users")
+	assert.True(t, ok)
+	assert.Equal(t, "users", endpoint)
+	_, ok = linkEndpoint("https://gitlab.example.com/users")
+	assert.False(t, ok)
+	_, ok = linkEndpoint("")
+	assert.False(t, ok)
+}
+
+// TestListAll tests that every page is loaded, following either
+// X-Next-Page or Link headers, and that the query is kept on every page.
+func TestListAll(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "opened", r.URL.Query().Get("state"))
+		assert.Equal(t, "100", r.URL.Query().Get("per_page"))
+		switch r.URL.Query().Get("page") {
+		case "", "1":
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[{"iid": 1}, {"iid": 2}]`))
+		case "2":
+			w.Header().Set("X-Next-Page", "")
+			w.Write([]byte(`[{"iid": 3}]`))
+		}
+	})
+	mux.HandleFunc("GET /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("id_after") == "" {
+			// built on the gitlab external_url, not the address it is reached on
+			next := "https://gitlab.example.com/api/v4/users?id_after=2&pagination=keyset&per_page=100"
+			w.Header().Set("Link", `<`+next+`>; rel="next"`)
+			w.Write([]byte(`[{"id": 1}, {"id": 2}]`))
+			return
+		}
+		w.Write([]byte(`[{"id": 3}]`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	mrs, err := c.MergeRequests(context.Background(), 3, url.Values{"state": {"opened"}})
+	assert.NoError(t, err)
+	assert.Len(t, mrs, 3)
+
+	users, err := c.Users(context.Background(), url.Values{"pagination": {"keyset"}})
+	assert.NoError(t, err)
+	assert.Len(t, users, 3)
+
+	// the token is never sent outside of the gitlab API
+	err = c.Get(context.Background(), "https://elsewhere.example.com/api/v4/users", nil)
+	assert.Error(t, err)
+}
+
+// TestRetry tests that 5xx and 429 replies are retried, but not a POST
+// failing with 5xx.
+func TestRetry(t *testing.T) {
+	calls := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		calls["get"]++
+		switch calls["get"] {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"iid": 7}`))
+		}
+	})
+	mux.HandleFunc("POST /api/v4/projects/3/merge_requests/7/notes", func(w http.ResponseWriter, r *http.Request) {
+		calls["post"]++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("GET /api/v4/projects/4/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		calls["down"]++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	mr, err := c.MergeRequest(context.Background(), 3, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, mr.Iid)
+	assert.Equal(t, 3, calls["get"])
+
+	_, err = c.CreateNote(context.Background(), 3, 7, "LGTM")
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls["post"])
+
+	_, err = c.MergeRequest(context.Background(), 4, 7)
+	assert.Error(t, err)
+	assert.Equal(t, DefaultRetries+1, calls["down"])
+}
+
+// TestErrors tests the typed errors of 401, 403 and 404 replies.
+func TestErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/{id}/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.PathValue("id"))
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message": "nope"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	for status, target := range map[int]error{401: ErrUnauthorized, 403: ErrForbidden, 404: ErrNotFound} {
+		_, err := c.MergeRequest(context.Background(), status, 7)
+		assert.ErrorIs(t, err, target)
+		assert.ErrorIs(t, err, apperr.ErrUpstream)
+		var apiErr *Error
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, status, apiErr.StatusCode)
+		assert.Equal(t, "nope", apiErr.Message)
+	}
+	_, err := c.MergeRequest(context.Background(), 400, 7)
+	assert.NotErrorIs(t, err, ErrNotFound)
+}
+
+// TestRateLimit tests that requests wait for the rate limit to reset once
+// exhausted, and give up when the context is done.
+func TestRateLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RateLimit-Remaining", "0")
+		w.Header().Set("RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+		w.Write([]byte(`{"iid": 7}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	_, err := c.MergeRequest(context.Background(), 3, 7)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.MergeRequest(ctx, 3, 7)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// TestRetryAfter tests the parsing of the Retry-After header.
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 8, 2, 18, 0, 0, 0, time.UTC)
+	d, ok := retryAfter(http.Header{"Retry-After": {"30"}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+	d, ok = retryAfter(http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+	_, ok = retryAfter(http.Header{}, now)
+	assert.False(t, ok)
+}
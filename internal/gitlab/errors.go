@@ -0,0 +1,75 @@
+//
+// errors.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrUnauthorized is matched by errors of requests replied with 401,
+	// e.g. an invalid or expired token.
+	ErrUnauthorized = errors.New("gitlab: unauthorized")
+	// ErrForbidden is matched by errors of requests replied with 403, e.g.
+	// a token missing a scope or a role.
+	ErrForbidden = errors.New("gitlab: forbidden")
+	// ErrNotFound is matched by errors of requests replied with 404.
+	ErrNotFound = errors.New("gitlab: not found")
+)
+
+// Error is a gitlab reply with an error status. Use errors.Is with
+// ErrUnauthorized, ErrForbidden or ErrNotFound to check the common ones.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	// Message is the error reported by gitlab, if any.
+	Message string
+}
+
+func newError(method string, path string, resp *http.Response, reply []byte) *Error {
+	e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	var body struct {
+		Message interface{} `json:"message"`
+		Error   string      `json:"error"`
+	}
+	if json.Unmarshal(reply, &body) == nil {
+		switch {
+		case body.Message != nil:
+			e.Message = fmt.Sprint(body.Message)
+		case body.Error != "":
+			e.Message = body.Error
+		}
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("gitlab replied %s for %s %s", e.Status, e.Method, e.Path)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is matches the sentinel error of the status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	}
+	return false
+}
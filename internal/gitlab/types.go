@@ -0,0 +1,231 @@
+//
+// types.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package gitlab
+
+import "time"
+
+// MergeRequest is a merge request.
+type MergeRequest struct {
+	// Based on gitlab version 17.2
+	ID             int         `json:"id"`
+	Iid            int         `json:"iid"`
+	ProjectID      int         `json:"project_id"`
+	Title          string      `json:"title"`
+	Description    string      `json:"description"`
+	State          string      `json:"state"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	MergedBy       interface{} `json:"merged_by"`
+	MergeUser      interface{} `json:"merge_user"`
+	MergedAt       interface{} `json:"merged_at"`
+	ClosedBy       interface{} `json:"closed_by"`
+	ClosedAt       interface{} `json:"closed_at"`
+	TargetBranch   string      `json:"target_branch"`
+	SourceBranch   string      `json:"source_branch"`
+	UserNotesCount int         `json:"user_notes_count"`
+	Upvotes        int         `json:"upvotes"`
+	Downvotes      int         `json:"downvotes"`
+	Author         struct {
+		ID        int    `json:"id"`
+		Username  string `json:"username"`
+		Name      string `json:"name"`
+		State     string `json:"state"`
+		Locked    bool   `json:"locked"`
+		AvatarURL string `json:"avatar_url"`
+		WebURL    string `json:"web_url"`
+	} `json:"author"`
+	Assignees                 []interface{} `json:"assignees"`
+	Assignee                  interface{}   `json:"assignee"`
+	Reviewers                 []User        `json:"reviewers"`
+	SourceProjectID           int           `json:"source_project_id"`
+	TargetProjectID           int           `json:"target_project_id"`
+	Labels                    []interface{} `json:"labels"`
+	Draft                     bool          `json:"draft"`
+	Imported                  bool          `json:"imported"`
+	ImportedFrom              string        `json:"imported_from"`
+	WorkInProgress            bool          `json:"work_in_progress"`
+	Milestone                 interface{}   `json:"milestone"`
+	MergeWhenPipelineSucceeds bool          `json:"merge_when_pipeline_succeeds"`
+	MergeStatus               string        `json:"merge_status"`
+	MergeError                string        `json:"merge_error"`
+	DetailedMergeStatus       string        `json:"detailed_merge_status"`
+	Sha                       string        `json:"sha"`
+	MergeCommitSha            interface{}   `json:"merge_commit_sha"`
+	SquashCommitSha           interface{}   `json:"squash_commit_sha"`
+	DiscussionLocked          interface{}   `json:"discussion_locked"`
+	ShouldRemoveSourceBranch  interface{}   `json:"should_remove_source_branch"`
+	ForceRemoveSourceBranch   bool          `json:"force_remove_source_branch"`
+	PreparedAt                time.Time     `json:"prepared_at"`
+	Reference                 string        `json:"reference"`
+	References                struct {
+		Short    string `json:"short"`
+		Relative string `json:"relative"`
+		Full     string `json:"full"`
+	} `json:"references"`
+	WebURL    string `json:"web_url"`
+	TimeStats struct {
+		TimeEstimate        int         `json:"time_estimate"`
+		TotalTimeSpent      int         `json:"total_time_spent"`
+		HumanTimeEstimate   interface{} `json:"human_time_estimate"`
+		HumanTotalTimeSpent interface{} `json:"human_total_time_spent"`
+	} `json:"time_stats"`
+	Squash               bool `json:"squash"`
+	SquashOnMerge        bool `json:"squash_on_merge"`
+	TaskCompletionStatus struct {
+		Count          int `json:"count"`
+		CompletedCount int `json:"completed_count"`
+	} `json:"task_completion_status"`
+	HasConflicts                bool `json:"has_conflicts"`
+	BlockingDiscussionsResolved bool `json:"blocking_discussions_resolved"`
+	// only returned when getting a single MR
+	HeadPipeline *Pipeline `json:"head_pipeline"`
+}
+
+// Pipeline is a CI pipeline, as embedded in a merge request.
+type Pipeline struct {
+	// Based on gitlab version 17.2
+	ID     int    `json:"id"`
+	Iid    int    `json:"iid"`
+	Sha    string `json:"sha"`
+	Ref    string `json:"ref"`
+	Status string `json:"status"`
+	WebURL string `json:"web_url"`
+}
+
+// Approvals is the approval state of a merge request.
+type Approvals struct {
+	// Based on gitlab version 17.2
+	UserHasApproved bool `json:"user_has_approved"`
+	UserCanApprove  bool `json:"user_can_approve"`
+	Approved        bool `json:"approved"`
+	ApprovedBy      []struct {
+		User struct {
+			ID        int    `json:"id"`
+			Username  string `json:"username"`
+			Name      string `json:"name"`
+			State     string `json:"state"`
+			Locked    bool   `json:"locked"`
+			AvatarURL string `json:"avatar_url"`
+			WebURL    string `json:"web_url"`
+		} `json:"user"`
+	} `json:"approved_by"`
+}
+
+// User is a gitlab user.
+type User struct {
+	// Based on gitlab version 17.2
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+}
+
+// Commit is a commit of a merge request.
+type Commit struct {
+	// Based on gitlab version 17.2
+	ID             string `json:"id"`
+	ShortID        string `json:"short_id"`
+	Title          string `json:"title"`
+	AuthorName     string `json:"author_name"`
+	AuthorEmail    string `json:"author_email"`
+	CommitterName  string `json:"committer_name"`
+	CommitterEmail string `json:"committer_email"`
+}
+
+// LabelEvent is a label added to or removed from a merge request.
+type LabelEvent struct {
+	// Based on gitlab version 17.2
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Action    string    `json:"action"`
+	User      struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	Label struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"label"`
+}
+
+// ProtectedBranch is a protected branch, or wildcard, of a project.
+type ProtectedBranch struct {
+	// Based on gitlab version 17.2
+	ID                        int    `json:"id"`
+	Name                      string `json:"name"`
+	AllowForcePush            bool   `json:"allow_force_push"`
+	CodeOwnerApprovalRequired bool   `json:"code_owner_approval_required"`
+}
+
+// ExternalStatusCheck is an external status check of a project.
+type ExternalStatusCheck struct {
+	// Based on gitlab version 17.2
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	ProjectID   int    `json:"project_id"`
+	ExternalURL string `json:"external_url"`
+}
+
+// StatusCheck is the state of an external status check for a merge request.
+type StatusCheck struct {
+	// Based on gitlab version 17.2
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	ExternalURL string `json:"external_url"`
+	Status      string `json:"status"`
+}
+
+// CommitStatus is a status of a commit.
+type CommitStatus struct {
+	// Based on gitlab version 17.2
+	ID          int    `json:"id"`
+	Sha         string `json:"sha"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Description string `json:"description"`
+}
+
+// Note is a merge request comment.
+type Note struct {
+	// Based on gitlab version 17.2
+	ID     int    `json:"id"`
+	Body   string `json:"body"`
+	System bool   `json:"system"`
+	Author struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+// Diff is a file changed by a merge request.
+type Diff struct {
+	// Based on gitlab version 17.2
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+}
+
+// Member is a member of a group.
+type Member struct {
+	// Based on gitlab version 17.2
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	AccessLevel int    `json:"access_level"`
+}
+
+// Version is the version of the gitlab instance.
+type Version struct {
+	// Based on gitlab version 17.2
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+}
@@ -0,0 +1,340 @@
+//
+// queue.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package queue is a durable work queue. Every change is appended, as a
+// json line, to a log file replayed when the queue is opened, so items
+// survive restarts until they are done.
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// compactEvery is how many records are appended before the log is rewritten
+// with only the pending items.
+const compactEvery = 1000
+
+// ErrClosed is returned by Pop once the queue is closed.
+var ErrClosed = errors.New("queue closed")
+
+// Item is a unit of work of the queue.
+type Item struct {
+	ID       uint64          `json:"id"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Attempts int             `json:"attempts,omitempty"`
+	// NotBefore delays the item, e.g. for a retry.
+	NotBefore time.Time `json:"not_before,omitempty"`
+}
+
+// record is a line of the log file.
+type record struct {
+	Op string `json:"op"`
+	Item
+}
+
+const (
+	opAdd   = "add"
+	opRetry = "retry"
+	opDone  = "done"
+)
+
+// Queue is a FIFO of items, each delivered to one consumer at a time.
+type Queue struct {
+	mu       sync.Mutex
+	path     string
+	f        *os.File
+	records  int
+	nextID   uint64
+	pending  []*Item
+	inflight map[uint64]*Item
+	closed   bool
+	// wake is signaled when an item is added or the queue is closed.
+	wake chan struct{}
+}
+
+// Open loads the queue persisted at path, creating it if needed. The queue
+// is kept only in memory when path is empty.
+func Open(path string) (*Queue, error) {
+	q := &Queue{path: path, nextID: 1, inflight: map[uint64]*Item{}, wake: make(chan struct{}, 1)}
+	if path == "" {
+		return q, nil
+	}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// replay loads the pending items from the log file.
+func (q *Queue) replay() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed loading queue")
+	}
+	defer f.Close()
+	items := map[uint64]*Item{}
+	var order []uint64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r record
+		// a partial last line, after a crash, is ignored
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		if r.ID >= q.nextID {
+			q.nextID = r.ID + 1
+		}
+		switch r.Op {
+		case opAdd:
+			item := r.Item
+			items[r.ID] = &item
+			order = append(order, r.ID)
+		case opRetry:
+			if item, ok := items[r.ID]; ok {
+				item.Attempts = r.Attempts
+				item.NotBefore = r.NotBefore
+			}
+		case opDone:
+			delete(items, r.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed loading queue")
+	}
+	for _, id := range order {
+		if item, ok := items[id]; ok {
+			q.pending = append(q.pending, item)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log file with only the pending and in flight items.
+// It must be called with mu locked, or before the queue is used.
+func (q *Queue) compact() error {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed compacting queue")
+	}
+	w := bufio.NewWriter(f)
+	write := func(item *Item) error {
+		line, err := json.Marshal(record{Op: opAdd, Item: *item})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(line, '\n'))
+		return err
+	}
+	for _, item := range q.inflight {
+		if err == nil {
+			err = write(item)
+		}
+	}
+	for _, item := range q.pending {
+		if err == nil {
+			err = write(item)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, q.path)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed compacting queue")
+	}
+	if q.f != nil {
+		q.f.Close()
+	}
+	q.f, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed opening queue")
+	}
+	q.records = len(q.pending) + len(q.inflight)
+	return nil
+}
+
+// append writes the record to the log file. It must be called with mu
+// locked.
+func (q *Queue) append(op string, item Item) error {
+	if q.f == nil {
+		return nil
+	}
+	line, err := json.Marshal(record{Op: op, Item: item})
+	if err != nil {
+		return err
+	}
+	if _, err := q.f.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed writing queue")
+	}
+	if err := q.f.Sync(); err != nil {
+		return errors.Wrap(err, "failed writing queue")
+	}
+	q.records++
+	if q.records >= compactEvery && q.records > 2*(len(q.pending)+len(q.inflight)) {
+		return q.compact()
+	}
+	return nil
+}
+
+// signal wakes up a consumer waiting in Pop.
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Push adds payload at the end of the queue. The item is on disk when Push
+// returns.
+func (q *Queue) Push(payload []byte) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrClosed
+	}
+	item := &Item{ID: q.nextID, Payload: append(json.RawMessage(nil), payload...)}
+	if err := q.append(opAdd, *item); err != nil {
+		return 0, err
+	}
+	q.nextID++
+	q.pending = append(q.pending, item)
+	q.signal()
+	return item.ID, nil
+}
+
+// next removes from the pending items the first one ready at now. It
+// returns nil and the time the next item gets ready, zero if none.
+// It must be called with mu locked.
+func (q *Queue) next(now time.Time) (*Item, time.Time) {
+	var wakeAt time.Time
+	for i, item := range q.pending {
+		if !item.NotBefore.After(now) {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.inflight[item.ID] = item
+			return item, time.Time{}
+		}
+		if wakeAt.IsZero() || item.NotBefore.Before(wakeAt) {
+			wakeAt = item.NotBefore
+		}
+	}
+	return nil, wakeAt
+}
+
+// Pop waits for the first ready item and hands it to the caller, who must
+// then call Done or Retry. Items are kept until Done, even across restarts.
+func (q *Queue) Pop(ctx context.Context) (Item, error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return Item{}, ErrClosed
+		}
+		item, wakeAt := q.next(time.Now())
+		if item != nil {
+			// let another consumer check the remaining items
+			if len(q.pending) > 0 {
+				q.signal()
+			}
+			q.mu.Unlock()
+			return *item, nil
+		}
+		q.mu.Unlock()
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !wakeAt.IsZero() {
+			timer = time.NewTimer(time.Until(wakeAt))
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return Item{}, ctx.Err()
+		}
+	}
+}
+
+// Done removes the item from the queue.
+func (q *Queue) Done(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	if _, ok := q.inflight[id]; !ok {
+		return nil
+	}
+	delete(q.inflight, id)
+	return q.append(opDone, Item{ID: id})
+}
+
+// Retry puts the item back in the queue, to be delivered again after delay.
+func (q *Queue) Retry(id uint64, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	item, ok := q.inflight[id]
+	if !ok {
+		return nil
+	}
+	delete(q.inflight, id)
+	item.Attempts++
+	item.NotBefore = time.Now().Add(delay)
+	q.pending = append(q.pending, item)
+	q.signal()
+	return q.append(opRetry, Item{ID: id, Attempts: item.Attempts, NotBefore: item.NotBefore})
+}
+
+// Len returns how many items are pending or in flight.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) + len(q.inflight)
+}
+
+// Close stops the queue. Pending and in flight items stay in the log file.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.wake)
+	if q.f != nil {
+		return q.f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+//
+// queue_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestQueue tests that items are delivered in order and survive a restart
+// until done, keeping their retries.
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	q, err := Open(path)
+	assert.NoError(t, err)
+	for _, p := range []string{`"a"`, `"b"`, `"c"`} {
+		_, err := q.Push([]byte(p))
+		assert.NoError(t, err)
+	}
+	ctx := context.Background()
+	a, err := q.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `"a"`, string(a.Payload))
+	assert.NoError(t, q.Done(a.ID))
+	b, err := q.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `"b"`, string(b.Payload))
+	assert.NoError(t, q.Retry(b.ID, time.Hour))
+	assert.Equal(t, 2, q.Len())
+	// "c" is popped but not done when stopping
+	_, err = q.Pop(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Close())
+	_, err = q.Pop(ctx)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	// a partial line, written by a crash, is ignored
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	f.Write([]byte(`{"op": "add", "id": 9, "payl`))
+	f.Close()
+
+	q, err = Open(path)
+	assert.NoError(t, err)
+	defer q.Close()
+	assert.Equal(t, 2, q.Len())
+	c, err := q.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `"c"`, string(c.Payload))
+	q.mu.Lock()
+	retried := q.pending[0]
+	q.mu.Unlock()
+	assert.Equal(t, b.ID, retried.ID)
+	assert.Equal(t, 1, retried.Attempts)
+	assert.True(t, retried.NotBefore.After(time.Now()))
+	id, err := q.Push([]byte(`"d"`))
+	assert.NoError(t, err)
+	assert.Greater(t, id, c.ID)
+}
+
+// TestPopWaits tests that Pop waits for a delayed item, or for a new one,
+// and gives up when the context is done.
+func TestPopWaits(t *testing.T) {
+	q, err := Open("")
+	assert.NoError(t, err)
+	defer q.Close()
+	ctx := context.Background()
+	id, _ := q.Push([]byte(`"a"`))
+	item, _ := q.Pop(ctx)
+	assert.NoError(t, q.Retry(id, 20*time.Millisecond))
+	start := time.Now()
+	item, err = q.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, id, item.ID)
+	assert.Equal(t, 1, item.Attempts)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Push([]byte(`"b"`))
+	}()
+	item, err = q.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `"b"`, string(item.Payload))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = q.Pop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// TestCompact tests that the log file only keeps the pending items once
+// compacted.
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	q, err := Open(path)
+	assert.NoError(t, err)
+	for i := 0; i < compactEvery; i++ {
+		q.Push([]byte(`"done"`))
+		item, _ := q.Pop(context.Background())
+		q.Done(item.ID)
+	}
+	q.Push([]byte(`"pending"`))
+	q.Close()
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Less(t, len(content), 1000)
+
+	q, err = Open(path)
+	assert.NoError(t, err)
+	defer q.Close()
+	assert.Equal(t, 1, q.Len())
+}
@@ -0,0 +1,285 @@
+//
+// store.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package store writes the merge status of MRs in the gitlab database. Every
+// query is a prepared statement with parameters, never built from values,
+// and every error is of kind apperr.Database.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNotFound is returned when the MR is not in the merge_requests table.
+var ErrNotFound = errors.New("merge request not found in database")
+
+const (
+	selectMergeRequest = `SELECT id, target_project_id, iid, description, merge_status, merge_error
+		FROM merge_requests WHERE target_project_id = $1 AND iid = $2 FOR UPDATE`
+	updateMergeStatus = `UPDATE merge_requests SET merge_status = $1, merge_error = $2
+		WHERE target_project_id = $3 AND iid = $4`
+)
+
+const (
+	// DefaultRetries is how many times a write failing with a transient
+	// error is retried.
+	DefaultRetries = 3
+	// DefaultBackoff is the delay before the first retry, doubled on each
+	// retry.
+	DefaultBackoff = 200 * time.Millisecond
+)
+
+// PoolOptions sizes the pool of connections. Zero values keep the
+// database/sql defaults.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// MergeRequest is a row of the gitlab merge_requests table.
+type MergeRequest struct {
+	Id              int            `db:"id"`
+	TargetProjectId int            `db:"target_project_id"`
+	Iid             int            `db:"iid"`
+	Description     sql.NullString `db:"description"`
+	MergeStatus     string         `db:"merge_status"`
+	MergeError      sql.NullString `db:"merge_error"`
+}
+
+// Store reads and writes the gitlab merge_requests table.
+type Store struct {
+	db *sqlx.DB
+	// Retries is how many times a write failing with a transient error,
+	// e.g. a lost connection, is retried.
+	Retries int
+	// Backoff is the delay before the first retry, doubled on each retry.
+	Backoff time.Duration
+
+	mu sync.Mutex
+	// selectStmt and updateStmt are prepared on first use, so the store can
+	// be created while the database is down.
+	selectStmt *sqlx.Stmt
+	updateStmt *sqlx.Stmt
+}
+
+// New creates a store over the db pool.
+func New(db *sqlx.DB) *Store {
+	return &Store{db: db, Retries: DefaultRetries, Backoff: DefaultBackoff}
+}
+
+// Open creates a store over a new pool of connections to the postgres
+// database at conn, sized by opts. No connection is made until needed.
+func Open(conn string, opts PoolOptions) (*Store, error) {
+	db, err := sqlx.Open("postgres", conn)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Config, errors.Wrap(err, "failed opening database"))
+	}
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	return New(db), nil
+}
+
+// DB returns the pool of the store.
+func (s *Store) DB() *sqlx.DB {
+	return s.db
+}
+
+// prepare prepares the statements of the store, if not done yet.
+func (s *Store) prepare(ctx context.Context) (*sqlx.Stmt, *sqlx.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.selectStmt == nil {
+		stmt, err := s.db.PreparexContext(ctx, selectMergeRequest)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed preparing merge request query")
+		}
+		s.selectStmt = stmt
+	}
+	if s.updateStmt == nil {
+		stmt, err := s.db.PreparexContext(ctx, updateMergeStatus)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed preparing merge status update")
+		}
+		s.updateStmt = stmt
+	}
+	return s.selectStmt, s.updateStmt, nil
+}
+
+// Transient reports if err is a database error that may not happen again,
+// e.g. a lost connection, a server restarting or a deadlock.
+func Transient(err error) bool {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &pqErr):
+		switch pqErr.Code.Class() {
+		case "08", "53", "57":
+			// connection exception, insufficient resources, operator intervention
+			return true
+		case "40":
+			// serialization failure, deadlock
+			return true
+		}
+		return false
+	case errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// UpdateMergeStatus sets merge_status and merge_error of the MR, merge_error
+// being NULL when empty. The row is locked, read and updated, unless it
+// already holds the values, in a single transaction, rolled back unless
+// exactly one row is updated. Transient errors are retried with exponential
+// backoff.
+func (s *Store) UpdateMergeStatus(ctx context.Context, project_id int, mr_id int, status string, mr_error string) error {
+	for attempt := 0; ; attempt++ {
+		err := s.updateMergeStatus(ctx, project_id, mr_id, status, mr_error)
+		if err == nil || !Transient(err) || attempt >= s.Retries {
+			return apperr.Wrap(apperr.Database, err)
+		}
+		delay := s.Backoff << attempt
+		log.Warn().Err(err).Int("project_id", project_id).Int("mr", mr_id).Dur("delay", delay).Int("attempt", attempt+1).Msg("retrying merge status update")
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return apperr.Wrap(apperr.Database, err)
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Store) updateMergeStatus(ctx context.Context, project_id int, mr_id int, status string, mr_error string) error {
+	selectStmt, updateStmt, err := s.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed starting transaction")
+	}
+	// no-op once committed
+	defer tx.Rollback()
+
+	var mr MergeRequest
+	err = tx.StmtxContext(ctx, selectStmt).GetContext(ctx, &mr, project_id, mr_id)
+	if err == sql.ErrNoRows {
+		return errors.Wrapf(ErrNotFound, "project %d, MR %d", project_id, mr_id)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed reading merge request")
+	}
+	log.Debug().Any("row", mr).Msg("before update")
+	if mr.MergeStatus == status && mr.MergeError.String == mr_error {
+		// gitlab may have reset merge_status since the last write, so only
+		// the row tells if it is unchanged
+		log.Debug().Int("project_id", project_id).Int("mr", mr_id).Str("status", status).Msg("merge status unchanged, not written")
+		return nil
+	}
+	res, err := tx.StmtxContext(ctx, updateStmt).ExecContext(ctx, status, sql.NullString{String: mr_error, Valid: mr_error != ""}, project_id, mr_id)
+	if err != nil {
+		return errors.Wrap(err, "failed updating merge status")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed updating merge status")
+	}
+	if n != 1 {
+		return fmt.Errorf("merge status update of project %d, MR %d changed %d rows, expected 1", project_id, mr_id, n)
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed committing merge status")
+	}
+	return nil
+}
+
+// Columns returns the type of every column of the table, in the current
+// schema, by column name. It is empty if the table does not exist.
+func (s *Store) Columns(ctx context.Context, table string) (map[string]string, error) {
+	var rows []struct {
+		Name string `db:"column_name"`
+		Type string `db:"data_type"`
+	}
+	err := s.db.SelectContext(ctx, &rows, `SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`, table)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Database, errors.Wrapf(err, "failed reading columns of %s", table))
+	}
+	columns := make(map[string]string, len(rows))
+	for _, r := range rows {
+		columns[r.Name] = r.Type
+	}
+	return columns, nil
+}
+
+// Health is the state of the pool of the store.
+type Health struct {
+	Up    bool   `json:"up"`
+	Error string `json:"error,omitempty"`
+	// pool statistics, see sql.DBStats
+	MaxOpenConnections int           `json:"max_open_connections"`
+	OpenConnections    int           `json:"open_connections"`
+	InUse              int           `json:"in_use"`
+	Idle               int           `json:"idle"`
+	WaitCount          int64         `json:"wait_count"`
+	WaitDuration       time.Duration `json:"wait_duration_ns"`
+}
+
+// Health pings the database and returns the state of the pool.
+func (s *Store) Health(ctx context.Context) Health {
+	var h Health
+	if err := s.db.PingContext(ctx); err != nil {
+		h.Error = err.Error()
+	} else {
+		h.Up = true
+	}
+	stats := s.db.Stats()
+	h.MaxOpenConnections = stats.MaxOpenConnections
+	h.OpenConnections = stats.OpenConnections
+	h.InUse = stats.InUse
+	h.Idle = stats.Idle
+	h.WaitCount = stats.WaitCount
+	h.WaitDuration = stats.WaitDuration
+	return h
+}
+
+// Close closes the statements and the pool of the store.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stmt := range []*sqlx.Stmt{s.selectStmt, s.updateStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	s.selectStmt, s.updateStmt = nil, nil
+	return s.db.Close()
+}
@@ -0,0 +1,280 @@
+//
+// store_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDB is a database/sql driver recording the statements it runs. It has
+// one merge_requests row, its merge_status and merge_error, per key of rows;
+// updates change affected rows.
+type fakeDB struct {
+	mu       sync.Mutex
+	rows     map[int][2]driver.Value
+	affected int64
+	prepared []string
+	updates  [][]driver.Value
+	commits  int
+	rollback int
+	// execErrs are returned, in order, by the next updates
+	execErrs []error
+}
+
+func (d *fakeDB) Open(name string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+// fakeConnector opens connections to a fakeDB, without registering a
+// driver, so every test has its own.
+type fakeConnector struct{ d *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{c.d}, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return c.d }
+
+type fakeConn struct{ d *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.prepared = append(c.d.prepared, query)
+	return fakeStmt{c.d, query}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{c.d}, nil }
+
+type fakeTx struct{ d *fakeDB }
+
+func (t fakeTx) Commit() error {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	t.d.commits++
+	return nil
+}
+
+func (t fakeTx) Rollback() error {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	t.d.rollback++
+	return nil
+}
+
+type fakeStmt struct {
+	d     *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.updates = append(s.d.updates, args)
+	if len(s.d.execErrs) > 0 {
+		err := s.d.execErrs[0]
+		s.d.execErrs = s.d.execErrs[1:]
+		return nil, err
+	}
+	if iid := int(args[3].(int64)); s.d.affected > 0 {
+		s.d.rows[iid] = [2]driver.Value{args[0], args[1]}
+	}
+	return driver.RowsAffected(s.d.affected), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if strings.Contains(s.query, "information_schema") {
+		return &columnRows{columns: [][]driver.Value{{"iid", "integer"}, {"merge_status", "character varying"}}}, nil
+	}
+	iid := int(args[1].(int64))
+	row, found := s.d.rows[iid]
+	return &fakeRows{found: found, row: row, iid: iid, project_id: int(args[0].(int64))}, nil
+}
+
+type fakeRows struct {
+	found      bool
+	row        [2]driver.Value
+	done       bool
+	iid        int
+	project_id int
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "target_project_id", "iid", "description", "merge_status", "merge_error"}
+}
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if !r.found || r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, []driver.Value{int64(1), int64(r.project_id), int64(r.iid), nil, r.row[0], r.row[1]})
+	return nil
+}
+
+// columnRows are rows of information_schema.columns.
+type columnRows struct {
+	columns [][]driver.Value
+}
+
+func (r *columnRows) Columns() []string { return []string{"column_name", "data_type"} }
+func (r *columnRows) Close() error      { return nil }
+
+func (r *columnRows) Next(dest []driver.Value) error {
+	if len(r.columns) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.columns[0])
+	r.columns = r.columns[1:]
+	return nil
+}
+
+// newFakeStore creates a store over a new fakeDB.
+func newFakeStore(t *testing.T) (*Store, *fakeDB) {
+	d := &fakeDB{rows: map[int][2]driver.Value{7: {"can_be_merged", nil}}, affected: 1}
+	s := New(sqlx.NewDb(sql.OpenDB(fakeConnector{d}), "postgres"))
+	s.Backoff = time.Millisecond
+	return s, d
+}
+
+// TestUpdateMergeStatus tests that the values are sent as parameters, in a
+// committed transaction, and merge_error is NULL when empty.
+func TestUpdateMergeStatus(t *testing.T) {
+	s, d := newFakeStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	mr_error := "Missing approvals: @o'brien"
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "cannot_be_merged", mr_error))
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "can_be_merged", ""))
+	assert.Equal(t, 2, d.commits)
+	assert.Len(t, d.updates, 2)
+	assert.Equal(t, []driver.Value{"cannot_be_merged", mr_error, int64(3), int64(7)}, d.updates[0])
+	assert.Equal(t, []driver.Value{"can_be_merged", nil, int64(3), int64(7)}, d.updates[1])
+	for _, q := range d.prepared {
+		assert.NotContains(t, q, "o'brien")
+		assert.True(t, strings.Contains(q, "$1"))
+	}
+}
+
+// TestUpdateMergeStatusErrors tests that the transaction is rolled back when
+// the MR is missing or when the update does not change exactly one row.
+func TestUpdateMergeStatusErrors(t *testing.T) {
+	s, d := newFakeStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	err := s.UpdateMergeStatus(ctx, 3, 8, "can_be_merged", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, err, apperr.ErrDatabase)
+	assert.Empty(t, d.updates)
+
+	d.affected = 2
+	err = s.UpdateMergeStatus(ctx, 3, 7, "cannot_be_merged", "blocked")
+	assert.ErrorContains(t, err, "changed 2 rows, expected 1")
+	assert.Equal(t, 0, d.commits)
+	assert.Equal(t, 2, d.rollback)
+}
+
+// TestUpdateMergeStatusRetry tests that transient errors are retried, up to
+// Retries times, and others are not.
+func TestUpdateMergeStatusRetry(t *testing.T) {
+	s, d := newFakeStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	deadlock := &pq.Error{Code: "40P01"}
+	d.execErrs = []error{deadlock, deadlock}
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "cannot_be_merged", "blocked"))
+	assert.Len(t, d.updates, 3)
+	assert.Equal(t, 1, d.commits)
+
+	d.updates = nil
+	d.execErrs = []error{deadlock, deadlock, deadlock, deadlock, deadlock}
+	assert.ErrorIs(t, s.UpdateMergeStatus(ctx, 3, 7, "can_be_merged", ""), deadlock)
+	assert.Len(t, d.updates, DefaultRetries+1)
+
+	d.updates = nil
+	d.execErrs = []error{&pq.Error{Code: "42601"}}
+	assert.Error(t, s.UpdateMergeStatus(ctx, 3, 7, "can_be_merged", ""))
+	assert.Len(t, d.updates, 1)
+}
+
+// TestUpdateMergeStatusUnchanged tests that the row is not written when it
+// already holds the values, but is when they were changed since, e.g. reset
+// by gitlab on a push.
+func TestUpdateMergeStatusUnchanged(t *testing.T) {
+	s, d := newFakeStore(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "cannot_be_merged", "blocked"))
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "cannot_be_merged", "blocked"))
+	assert.Len(t, d.updates, 1)
+
+	d.rows[7] = [2]driver.Value{"unchecked", nil}
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "cannot_be_merged", "blocked"))
+	assert.Len(t, d.updates, 2)
+
+	// NULL merge_error holds an empty error
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "can_be_merged", ""))
+	assert.NoError(t, s.UpdateMergeStatus(ctx, 3, 7, "can_be_merged", ""))
+	assert.Len(t, d.updates, 3)
+}
+
+// TestTransient tests the classification of database errors.
+func TestTransient(t *testing.T) {
+	assert.True(t, Transient(driver.ErrBadConn))
+	assert.True(t, Transient(&pq.Error{Code: "08006"}))
+	assert.True(t, Transient(&pq.Error{Code: "57P01"}))
+	assert.True(t, Transient(&pq.Error{Code: "40001"}))
+	assert.False(t, Transient(&pq.Error{Code: "42P01"}))
+	assert.False(t, Transient(ErrNotFound))
+	assert.False(t, Transient(sql.ErrNoRows))
+}
+
+// TestHealth tests that the health reports the pool state and ping errors.
+func TestHealth(t *testing.T) {
+	s, _ := newFakeStore(t)
+	defer s.Close()
+	s.DB().SetMaxOpenConns(4)
+	h := s.Health(context.Background())
+	assert.True(t, h.Up)
+	assert.Equal(t, 4, h.MaxOpenConnections)
+	assert.Equal(t, 1, h.OpenConnections)
+
+	down, err := Open("postgres://user@127.0.0.1:1/gitlabhq_production?sslmode=disable&connect_timeout=1", PoolOptions{MaxOpenConns: 2})
+	assert.NoError(t, err)
+	defer down.Close()
+	h = down.Health(context.Background())
+	assert.False(t, h.Up)
+	assert.NotEmpty(t, h.Error)
+	assert.Equal(t, 2, h.MaxOpenConnections)
+}
+
+// TestColumns tests the listing of the columns of a table.
+func TestColumns(t *testing.T) {
+	s, _ := newFakeStore(t)
+	defer s.Close()
+	columns, err := s.Columns(context.Background(), "merge_requests")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"iid": "integer", "merge_status": "character varying"}, columns)
+}
@@ -0,0 +1,258 @@
+//
+// assign.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// reviewerRotationFile is the file, in the state directory, used to persist
+// the round robin reviewer rotation.
+const reviewerRotationFile = "reviewers.json"
+
+// reviewerRotation keeps the last reviewer assigned for every approver group,
+// so the round robin strategy carries on where it stopped after a restart.
+type reviewerRotation struct {
+	mu   sync.Mutex
+	path string
+	// Last maps "<project_id>:<group>" to the last assigned username
+	Last map[string]string `json:"last"`
+}
+
+// newReviewerRotation creates a rotation persisted in dir. The rotation is
+// kept only in memory when dir is empty.
+func newReviewerRotation(dir string) (*reviewerRotation, error) {
+	r := &reviewerRotation{Last: map[string]string{}}
+	if dir == "" {
+		return r, nil
+	}
+	r.path = filepath.Join(dir, reviewerRotationFile)
+	content, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed loading reviewer rotation")
+	}
+	if err := json.Unmarshal(content, r); err != nil {
+		return nil, errors.Wrap(err, "failed parsing reviewer rotation")
+	}
+	if r.Last == nil {
+		r.Last = map[string]string{}
+	}
+	return r, nil
+}
+
+// save writes the rotation to disk. It must be called with mu locked.
+func (r *reviewerRotation) save() {
+	if r.path == "" {
+		return
+	}
+	content, err := json.Marshal(r)
+	if err != nil {
+		log.Err(err).Msg("failed encoding reviewer rotation")
+		return
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		log.Err(err).Msg("failed saving reviewer rotation")
+		return
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		log.Err(err).Msg("failed saving reviewer rotation")
+	}
+}
+
+// next picks count candidates following the last one assigned for key, in
+// alphabetical order, and records the last one picked.
+func (r *reviewerRotation) next(key string, candidates []string, count int) []string {
+	if count <= 0 || len(candidates) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// first candidate after the last one assigned, wrapping around
+	start := sort.SearchStrings(sorted, r.Last[key])
+	if start < len(sorted) && sorted[start] == r.Last[key] {
+		start++
+	}
+	var picked []string
+	for i := 0; i < count && i < len(sorted); i++ {
+		picked = append(picked, sorted[(start+i)%len(sorted)])
+	}
+	r.Last[key] = picked[len(picked)-1]
+	r.save()
+	return picked
+}
+
+// pickRandom picks count random candidates.
+func pickRandom(candidates []string, count int) []string {
+	shuffled := append([]string(nil), candidates...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	if count > len(shuffled) {
+		count = len(shuffled)
+	}
+	return shuffled[:count]
+}
+
+// pickLeastLoaded picks the count candidates with the lowest load, breaking
+// ties alphabetically.
+func pickLeastLoaded(candidates []string, load map[string]int, count int) []string {
+	sorted := append([]string(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if load[sorted[i]] != load[sorted[j]] {
+			return load[sorted[i]] < load[sorted[j]]
+		}
+		return sorted[i] < sorted[j]
+	})
+	if count > len(sorted) {
+		count = len(sorted)
+	}
+	return sorted[:count]
+}
+
+// reviewLoad returns how many opened MRs username is reviewing.
+func (s *Service) reviewLoad(ctx context.Context, username string) (int, error) {
+	return s.gitlab.CountMergeRequests(ctx, url.Values{"scope": {"all"}, "state": {"opened"}, "reviewer_username": {username}})
+}
+
+// userID returns the id of the gitlab user.
+func (s *Service) userID(ctx context.Context, username string) (int, error) {
+	users, err := s.gitlab.Users(ctx, url.Values{"username": {username}})
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range users {
+		if u.Username == username {
+			return u.ID, nil
+		}
+	}
+	return 0, errors.Errorf("user '%s' not found", username)
+}
+
+// reviewerCandidates returns the pending approvers of the group, with the
+// approver groups expanded, who can be assigned as reviewers.
+func (s *Service) reviewerCandidates(ctx context.Context, g GroupStatus, skip map[string]bool) []string {
+	var candidates []string
+	seen := map[string]bool{}
+	add := func(u string) {
+		if !skip[u] && !seen[u] {
+			seen[u] = true
+			candidates = append(candidates, u)
+		}
+	}
+	for _, a := range g.Pending() {
+		if !strings.HasPrefix(a, conf.GroupPrefix) {
+			add(a)
+			continue
+		}
+		usernames, err := s.groupMembers(ctx, strings.TrimPrefix(a, conf.GroupPrefix))
+		if err != nil {
+			log.Err(err).Str("group", a).Msg("failed resolving approver group")
+		}
+		for _, u := range usernames {
+			add(u)
+		}
+	}
+	return candidates
+}
+
+// pickReviewers selects, according to the strategy, count reviewers among
+// the candidates of the group.
+func (s *Service) pickReviewers(ctx context.Context, strategy string, key string, candidates []string, count int) []string {
+	switch strategy {
+	case conf.StrategyRandom:
+		return pickRandom(candidates, count)
+	case conf.StrategyLeastLoaded:
+		load := map[string]int{}
+		for _, c := range candidates {
+			n, err := s.reviewLoad(ctx, c)
+			if err != nil {
+				log.Err(err).Str("user", c).Msg("failed loading review load")
+				continue
+			}
+			load[c] = n
+		}
+		return pickLeastLoaded(candidates, load, count)
+	default:
+		return s.reviewers.next(key, candidates, count)
+	}
+}
+
+// assignReviewers adds, as reviewers of the MR, approvers for every group
+// still missing approvals. The author, the current reviewers and the users
+// who already approved are skipped.
+func (s *Service) assignReviewers(ctx context.Context, ar conf.ApprovRule, mr gitlab.MergeRequest, ev Evaluation) error {
+	if ar.Reviewers == nil {
+		return nil
+	}
+	skip := map[string]bool{mr.Author.Username: true}
+	ids := make([]int, 0, len(mr.Reviewers))
+	for _, r := range mr.Reviewers {
+		skip[r.Username] = true
+		ids = append(ids, r.ID)
+	}
+	for _, d := range ev.Discarded {
+		skip[d.Username] = true
+	}
+	for _, g := range ev.Groups {
+		for _, by := range g.ApprovedBy {
+			skip[by] = true
+		}
+	}
+	var added []string
+	for _, g := range ev.Groups {
+		// current reviewers are expected to approve
+		missing := g.Missing()
+		pending := g.Pending()
+		members, err := s.resolveGroupApprovers(ctx, []conf.ApproverGroup{{Approvals: pending}})
+		if err != nil {
+			return err
+		}
+		for _, r := range mr.Reviewers {
+			if isApprover(r.Username, pending, members) {
+				missing--
+			}
+		}
+		if missing <= 0 {
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", ar.ProjectId, g.Name)
+		for _, u := range s.pickReviewers(ctx, ar.Reviewers.Strategy, key, s.reviewerCandidates(ctx, g, skip), missing) {
+			id, err := s.userID(ctx, u)
+			if err != nil {
+				log.Err(err).Str("user", u).Msg("failed loading reviewer")
+				continue
+			}
+			skip[u] = true
+			ids = append(ids, id)
+			added = append(added, u)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	log.Info().Int("project_id", ar.ProjectId).Int("mr", mr.Iid).Strs("reviewers", added).Msg("assigning reviewers")
+	return s.gitlab.SetReviewers(ctx, ar.ProjectId, mr.Iid, ids)
+}
@@ -0,0 +1,93 @@
+//
+// assign_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReviewerRotation tests the round robin rotation and its persistence.
+func TestReviewerRotation(t *testing.T) {
+	dir := t.TempDir()
+	rotation, err := newReviewerRotation(dir)
+	assert.NoError(t, err)
+
+	candidates := []string{"carol", "alice", "bob"}
+	assert.Equal(t, []string{"alice"}, rotation.next("1:dev", candidates, 1))
+	assert.Equal(t, []string{"bob", "carol"}, rotation.next("1:dev", candidates, 2))
+	// other groups have their own rotation
+	assert.Equal(t, []string{"alice"}, rotation.next("1:ops", candidates, 1))
+
+	// reload from disk, the rotation carries on
+	rotation, err = newReviewerRotation(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, rotation.next("1:dev", candidates, 2))
+	// the last assigned user left the group
+	assert.Equal(t, []string{"carol"}, rotation.next("1:dev", []string{"alice", "carol"}, 1))
+	assert.Nil(t, rotation.next("1:dev", nil, 1))
+}
+
+// TestPickReviewers tests the random and least loaded strategies.
+func TestPickReviewers(t *testing.T) {
+	candidates := []string{"alice", "bob", "carol"}
+	picked := pickRandom(candidates, 2)
+	assert.Len(t, picked, 2)
+	assert.Subset(t, candidates, picked)
+	assert.Len(t, pickRandom(candidates, 5), 3)
+
+	load := map[string]int{"alice": 4, "bob": 1, "carol": 1}
+	assert.Equal(t, []string{"bob", "carol"}, pickLeastLoaded(candidates, load, 2))
+}
+
+// TestAssignReviewers tests that missing approvers are added as reviewers,
+// skipping the author and keeping the current reviewers.
+func TestAssignReviewers(t *testing.T) {
+	var reviewers []int
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		ids := map[string]string{"bob": "2", "carol": "3", "dave": "4"}
+		u := r.URL.Query().Get("username")
+		w.Write([]byte(`[{"id": ` + ids[u] + `, "username": "` + u + `"}]`))
+	})
+	mux.HandleFunc("PUT /api/v4/projects/3/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string][]int
+		json.NewDecoder(r.Body).Decode(&payload)
+		reviewers = payload["reviewer_ids"]
+		w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	rotation, _ := newReviewerRotation("")
+	s := &Service{Config: conf.Config{GitlabURL: srv.URL, GitlabToken: "glpat-token"}, gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()), reviewers: rotation}
+
+	var mr gitlab.MergeRequest
+	mr.Iid = 7
+	mr.Author.Username = "alice"
+	mr.Reviewers = []gitlab.User{{ID: 4, Username: "dave"}}
+	ev := Evaluation{Groups: []GroupStatus{
+		{Name: "dev", Required: 2, Approvers: []string{"alice", "bob", "carol", "dave"}},
+		{Name: "ops", Required: 1, Approvers: []string{"erin"}, ApprovedBy: []string{"erin"}},
+	}}
+	ar := conf.ApprovRule{ProjectId: 3, Reviewers: &conf.ReviewerAssignment{Strategy: conf.StrategyRoundRobin}}
+	assert.NoError(t, s.assignReviewers(context.Background(), ar, mr, ev))
+	// dave already reviews, one more approver is needed
+	assert.Equal(t, []int{4, 2}, reviewers)
+
+	// disabled
+	reviewers = nil
+	assert.NoError(t, s.assignReviewers(context.Background(), conf.ApprovRule{ProjectId: 3}, mr, ev))
+	assert.Nil(t, reviewers)
+}
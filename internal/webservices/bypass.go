@@ -0,0 +1,144 @@
+//
+// bypass.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// bypassLogFile is the file, in the state directory, where bypasses are
+// appended as json lines.
+const bypassLogFile = "bypass.log"
+
+// Bypass is the record of a label relaxing the approval rule of a MR.
+type Bypass struct {
+	Time         time.Time `json:"time"`
+	ProjectId    int       `json:"project_id"`
+	Iid          int       `json:"iid"`
+	TargetBranch string    `json:"target_branch"`
+	Label        string    `json:"label"`
+	AppliedBy    string    `json:"applied_by"`
+	AppliedAt    time.Time `json:"applied_at"`
+}
+
+func (b Bypass) key() string {
+	return fmt.Sprintf("%d:%d:%s:%d", b.ProjectId, b.Iid, b.Label, b.AppliedAt.Unix())
+}
+
+// bypassRecorder records every bypass once. Records are logged and, when a
+// state directory is configured, appended to the bypass log file.
+type bypassRecorder struct {
+	mu   sync.Mutex
+	path string
+	seen map[string]bool
+}
+
+// newBypassRecorder creates a recorder writing in dir, loading the records
+// already in the file to avoid duplicates.
+func newBypassRecorder(dir string) (*bypassRecorder, error) {
+	b := &bypassRecorder{seen: map[string]bool{}}
+	if dir == "" {
+		return b, nil
+	}
+	b.path = filepath.Join(dir, bypassLogFile)
+	f, err := os.Open(b.path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed loading bypass log")
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Bypass
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		b.seen[r.key()] = true
+	}
+	return b, scanner.Err()
+}
+
+// record stores the bypass if it was not recorded yet.
+func (b *bypassRecorder) record(r Bypass) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seen[r.key()] {
+		return
+	}
+	b.seen[r.key()] = true
+	log.Info().Int("project_id", r.ProjectId).Int("mr", r.Iid).Str("label", r.Label).Str("applied_by", r.AppliedBy).Time("applied_at", r.AppliedAt).Msg("approval rule bypassed")
+	if b.path == "" {
+		return
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.Err(err).Msg("failed encoding bypass")
+		return
+	}
+	f, err := os.OpenFile(b.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Err(err).Msg("failed saving bypass")
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Err(err).Msg("failed saving bypass")
+	}
+}
+
+// labelAppliedBy returns who added the label to the MR the last time, and when.
+func (s *Service) labelAppliedBy(ctx context.Context, project_id int, mr_id int, label string) (string, time.Time, error) {
+	events, err := s.gitlab.LabelEvents(ctx, project_id, mr_id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var by string
+	var at time.Time
+	for _, e := range events {
+		if e.Action == "add" && e.Label.Name == label && !e.CreatedAt.Before(at) {
+			by = e.User.Username
+			at = e.CreatedAt
+		}
+	}
+	return by, at, nil
+}
+
+// recordBypasses records the bypass labels applied to the MR.
+func (s *Service) recordBypasses(ctx context.Context, mr gitlab.MergeRequest, project_id int, labels []string) {
+	for _, label := range labels {
+		by, at, err := s.labelAppliedBy(ctx, project_id, mr.Iid, label)
+		if err != nil {
+			log.Err(err).Str("label", label).Msg("failed loading label events")
+		}
+		s.bypasses.record(Bypass{
+			Time:         time.Now(),
+			ProjectId:    project_id,
+			Iid:          mr.Iid,
+			TargetBranch: mr.TargetBranch,
+			Label:        label,
+			AppliedBy:    by,
+			AppliedAt:    at,
+		})
+	}
+}
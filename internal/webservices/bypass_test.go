@@ -0,0 +1,42 @@
+//
+// bypass_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBypassRecorder tests that every bypass is recorded once, across restarts.
+func TestBypassRecorder(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	b := Bypass{Time: time.Now(), ProjectId: 1, Iid: 2, Label: "hotfix", AppliedBy: "alice", AppliedAt: at}
+
+	r, err := newBypassRecorder(dir)
+	assert.NoError(t, err)
+	r.record(b)
+	r.record(b)
+
+	r, err = newBypassRecorder(dir)
+	assert.NoError(t, err)
+	r.record(b)
+	b.AppliedAt = at.Add(time.Hour)
+	r.record(b)
+
+	content, err := os.ReadFile(filepath.Join(dir, bypassLogFile))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"applied_by":"alice"`)
+}
@@ -0,0 +1,112 @@
+//
+// coalesce.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+// pendingEvaluation is the evaluation of a MR waiting, or running, in the
+// coalescer.
+type pendingEvaluation struct {
+	// again is set when another event asked for the evaluation after the
+	// current one started.
+	again bool
+	// opened is set when one of the events opened the MR.
+	opened bool
+}
+
+// mrCoalescer merges the evaluations asked by a burst of events for the
+// same MR, e.g. an approval, a label and a push within a second. Every MR
+// has at most one evaluation running and one more pending.
+type mrCoalescer struct {
+	mu  sync.Mutex
+	mrs map[string]*pendingEvaluation
+}
+
+// run calls eval for the MR after delay, unless an evaluation of the MR is
+// already pending, in which case it only asks it to run once more. opened
+// reports if the event opened the MR; eval gets it true if any of the
+// merged events did.
+func (c *mrCoalescer) run(ctx context.Context, project_id int, mr_id int, opened bool, delay time.Duration, eval func(ctx context.Context, opened bool) error) error {
+	key := mrKey(project_id, mr_id)
+	c.mu.Lock()
+	if c.mrs == nil {
+		c.mrs = map[string]*pendingEvaluation{}
+	}
+	if p, ok := c.mrs[key]; ok {
+		p.again = true
+		p.opened = p.opened || opened
+		c.mu.Unlock()
+		log.Debug().Int("project_id", project_id).Int("mr", mr_id).Msg("MR evaluation coalesced")
+		return nil
+	}
+	p := &pendingEvaluation{opened: opened}
+	c.mrs[key] = p
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.mrs, key)
+		c.mu.Unlock()
+	}()
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		c.mu.Lock()
+		opened := p.opened
+		p.again, p.opened = false, false
+		c.mu.Unlock()
+		if err := eval(ctx, opened); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		again := p.again
+		c.mu.Unlock()
+		if !again {
+			return nil
+		}
+	}
+}
+
+// reinforceEval returns the evaluation of the MR run by the coalescer.
+func (s *Service) reinforceEval(p conf.ApprovRule, mr_id int) func(ctx context.Context, opened bool) error {
+	return func(ctx context.Context, opened bool) error {
+		if opened {
+			return s.reinforceNewMr(ctx, p, mr_id)
+		}
+		return s.reinforceMrRule(ctx, p, mr_id)
+	}
+}
+
+// coalesceMr reinforces the rule of the MR through the coalescer, waiting
+// webhook_debounce for more events of the MR first.
+func (s *Service) coalesceMr(ctx context.Context, p conf.ApprovRule, mr_id int, opened bool) error {
+	debounce := time.Duration(s.Config.WebhookDebounce) * time.Millisecond
+	if s.Config.WebhookDebounce == 0 {
+		debounce = conf.DefaultWebhookDebounce * time.Millisecond
+	}
+	return s.coalescer.run(ctx, p.ProjectId, mr_id, opened, debounce, s.reinforceEval(p, mr_id))
+}
+
+// serializeMr reinforces the rule of the MR through the coalescer, without
+// waiting, so it never runs along with another evaluation of the MR, e.g.
+// of a webhook event, which could then overwrite it with an older state.
+// If an evaluation is already pending, it is only asked to run once more.
+func (s *Service) serializeMr(ctx context.Context, p conf.ApprovRule, mr_id int) error {
+	return s.coalescer.run(ctx, p.ProjectId, mr_id, false, 0, s.reinforceEval(p, mr_id))
+}
@@ -0,0 +1,100 @@
+//
+// coalesce_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCoalescer tests that a burst of events for the same MR runs one
+// evaluation, plus one for the events received while it runs, and that
+// other MRs are not delayed.
+func TestCoalescer(t *testing.T) {
+	var c mrCoalescer
+	var mu sync.Mutex
+	evals := map[int][]bool{}
+	running := make(chan struct{})
+	release := make(chan struct{})
+	eval := func(mr_id int) func(context.Context, bool) error {
+		return func(ctx context.Context, opened bool) error {
+			mu.Lock()
+			evals[mr_id] = append(evals[mr_id], opened)
+			n := len(evals[mr_id])
+			mu.Unlock()
+			if mr_id == 7 && n == 1 {
+				running <- struct{}{}
+				<-release
+			}
+			return nil
+		}
+	}
+	ctx := context.Background()
+	delay := 20 * time.Millisecond
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, c.run(ctx, 3, 7, false, delay, eval(7)))
+	}()
+	// merged in the pending evaluation
+	time.Sleep(delay / 4)
+	assert.NoError(t, c.run(ctx, 3, 7, false, delay, eval(7)))
+	<-running
+	// received while the evaluation runs, one more is needed
+	assert.NoError(t, c.run(ctx, 3, 7, true, delay, eval(7)))
+	assert.NoError(t, c.run(ctx, 3, 7, false, delay, eval(7)))
+	assert.NoError(t, c.run(ctx, 3, 8, false, delay, eval(8)))
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []bool{false, true}, evals[7])
+	assert.Equal(t, []bool{false}, evals[8])
+	assert.Empty(t, c.mrs)
+
+	// a cancelled evaluation does not run
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, c.run(cancelled, 3, 9, false, delay, eval(9)), context.Canceled)
+	assert.Empty(t, evals[9])
+}
+
+// TestSerializeMr tests that the reconciler, freeze and status check
+// evaluations of a MR never run along with its webhook evaluation: they only
+// ask it to run once more.
+func TestSerializeMr(t *testing.T) {
+	s := &Service{}
+	p := conf.ApprovRule{ProjectId: 3}
+	running := make(chan struct{})
+	release := make(chan struct{})
+	evals := 0
+	done := make(chan error)
+	go func() {
+		done <- s.coalescer.run(context.Background(), 3, 7, false, 0, func(ctx context.Context, opened bool) error {
+			evals++
+			if evals == 1 {
+				running <- struct{}{}
+				<-release
+			}
+			return nil
+		})
+	}()
+	<-running
+	// s has no gitlab client: evaluating the MR would panic
+	drifted, err := s.reconcileMr(context.Background(), p, 7)
+	assert.NoError(t, err)
+	assert.False(t, drifted)
+	assert.NoError(t, s.serializeMr(context.Background(), p, 7))
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2, evals)
+}
@@ -0,0 +1,215 @@
+//
+// compat.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+// The gitlab versions whose merge_requests table is supported by the db
+// enforcer, from minGitlabMajor.0 to maxGitlabMajor.x. A newer version is
+// accepted, with a warning, as long as its merge_requests table is.
+const (
+	minGitlabMajor = 16
+	maxGitlabMajor = 17
+)
+
+// mergeRequestColumns are the columns of merge_requests used by the db
+// enforcer, with their accepted types.
+var mergeRequestColumns = map[string][]string{
+	"target_project_id": {"integer", "bigint"},
+	"iid":               {"integer", "bigint"},
+	"merge_status":      {"character varying", "text"},
+	"merge_error":       {"text", "character varying"},
+}
+
+// versionProblems returns why the db enforcer does not support the gitlab
+// version, e.g. "17.2.1-ee", if it does not.
+func versionProblems(version string) []string {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return []string{fmt.Sprintf("unknown gitlab version '%s'", version)}
+	}
+	if major < minGitlabMajor || major > maxGitlabMajor {
+		return []string{fmt.Sprintf("gitlab version %s is not supported, only %d.0 to %d.x are", version, minGitlabMajor, maxGitlabMajor)}
+	}
+	return nil
+}
+
+// newerGitlabVersion reports if the gitlab version is newer than the ones
+// tested with the db enforcer.
+func newerGitlabVersion(version string) bool {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return err == nil && major > maxGitlabMajor
+}
+
+// schemaProblems returns why the db enforcer does not support the
+// merge_requests columns, by name, if it does not.
+func schemaProblems(columns map[string]string) []string {
+	if len(columns) == 0 {
+		return []string{"table merge_requests not found"}
+	}
+	var problems []string
+	for name, types := range mergeRequestColumns {
+		typ, ok := columns[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("column merge_requests.%s not found", name))
+		case !contains(types, typ):
+			problems = append(problems, fmt.Sprintf("column merge_requests.%s has type %s, expected %s", name, typ, strings.Join(types, " or ")))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// usesDBEnforcer reports if the db enforcer is used by any project.
+func (s *Service) usesDBEnforcer() bool {
+	for _, p := range s.Config.Projects {
+		if s.Config.ProjectEnforcer(p) == conf.EnforcerDB {
+			return true
+		}
+	}
+	return false
+}
+
+// compatState is the result of the last compatibility check of the db
+// enforcer.
+type compatState struct {
+	mu sync.Mutex
+	// unchecked is why the last check could not complete, e.g. gitlab
+	// restarting, empty if it did.
+	unchecked string
+	// problems lists why the gitlab version or schema is not supported.
+	problems []string
+}
+
+func (c *compatState) set(unchecked string, problems []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unchecked, c.problems = unchecked, problems
+}
+
+func (c *compatState) get() (string, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.unchecked, c.problems
+}
+
+// compatibilityProblems returns why the db enforcer does not support the
+// gitlab version or the merge_requests table, or an error if they could not
+// be read.
+func (s *Service) compatibilityProblems(ctx context.Context) ([]string, error) {
+	version, err := s.gitlab.Version(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading gitlab version: %w", err)
+	}
+	log.Info().Str("version", version.Version).Str("revision", version.Revision).Msg("gitlab version")
+	var schema []string
+	if s.db == nil {
+		schema = []string{"psql_conn_url is not defined"}
+	} else if columns, err := s.db.Columns(ctx, "merge_requests"); err != nil {
+		return nil, err
+	} else {
+		schema = schemaProblems(columns)
+	}
+	if len(schema) == 0 && newerGitlabVersion(version.Version) {
+		log.Warn().Str("version", version.Version).Msgf("gitlab version newer than %d.x, not tested with the db enforcer but merge_requests table supported", maxGitlabMajor)
+		return nil, nil
+	}
+	return append(versionProblems(version.Version), schema...), nil
+}
+
+// CheckCompatibility checks, if the db enforcer is used, that the gitlab
+// version and the merge_requests table are supported. If not, every problem
+// is logged and the db enforcer stops writing, or, with db_incompatible
+// "refuse", an error listing the problems is returned. When they cannot be
+// read, the db enforcer stops writing until a later check completes, see
+// RunCompatibilityChecks.
+func (s *Service) CheckCompatibility(ctx context.Context) error {
+	if !s.usesDBEnforcer() {
+		return nil
+	}
+	problems, err := s.compatibilityProblems(ctx)
+	if err != nil {
+		s.compat.set(err.Error(), nil)
+		log.Err(err).Msg("db enforcer compatibility not checked, the merge status is not written until it is")
+		return nil
+	}
+	s.compat.set("", problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		log.Error().Str("problem", p).Msg("db enforcer incompatible")
+	}
+	if s.Config.DBIncompatible == conf.DBIncompatibleRefuse {
+		return apperr.New(apperr.Config, "db enforcer incompatible: "+strings.Join(problems, "; "))
+	}
+	log.Warn().Msg("db enforcer in read-only mode, the merge status is not written")
+	return nil
+}
+
+// The delays between two compatibility checks, shorter while the last one
+// could not complete.
+const (
+	compatCheckInterval = time.Hour
+	compatRetryInterval = 30 * time.Second
+)
+
+// RunCompatibilityChecks checks again the db enforcer compatibility
+// periodically, every 30 seconds while it could not be checked, then every
+// hour, e.g. after a gitlab upgrade. It returns when ctx is done.
+func (s *Service) RunCompatibilityChecks(ctx context.Context) {
+	if !s.usesDBEnforcer() {
+		return
+	}
+	for {
+		interval := compatCheckInterval
+		if unchecked, _ := s.compat.get(); unchecked != "" {
+			interval = compatRetryInterval
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.CheckCompatibility(ctx); err != nil {
+			// too late to refuse starting, stay in read-only mode
+			log.Err(err).Msg("db enforcer in read-only mode, the merge status is not written")
+		}
+	}
+}
+
+// dbReadOnly reports if the db enforcer must not write, the gitlab version
+// or database schema not being supported, or not checked yet.
+func (s *Service) dbReadOnly() bool {
+	unchecked, problems := s.compat.get()
+	return unchecked != "" || len(problems) > 0
+}
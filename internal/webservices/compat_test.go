@@ -0,0 +1,122 @@
+//
+// compat_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVersionProblems tests the supported and the newer gitlab versions.
+func TestVersionProblems(t *testing.T) {
+	assert.Empty(t, versionProblems("17.2.1-ee"))
+	assert.Empty(t, versionProblems("16.0.0"))
+	assert.Equal(t, []string{"gitlab version 18.0.0 is not supported, only 16.0 to 17.x are"}, versionProblems("18.0.0"))
+	assert.Equal(t, []string{"gitlab version 15.11.3 is not supported, only 16.0 to 17.x are"}, versionProblems("15.11.3"))
+	assert.Equal(t, []string{"unknown gitlab version ''"}, versionProblems(""))
+	assert.True(t, newerGitlabVersion("18.0.0"))
+	assert.False(t, newerGitlabVersion("17.11.2-ee"))
+	assert.False(t, newerGitlabVersion("15.11.3"))
+	assert.False(t, newerGitlabVersion(""))
+}
+
+// TestSchemaProblems tests the check of the merge_requests columns.
+func TestSchemaProblems(t *testing.T) {
+	assert.Empty(t, schemaProblems(map[string]string{
+		"id":                "integer",
+		"target_project_id": "integer",
+		"iid":               "integer",
+		"merge_status":      "character varying",
+		"merge_error":       "text",
+	}))
+	assert.Equal(t, []string{"table merge_requests not found"}, schemaProblems(nil))
+	assert.Equal(t, []string{
+		"column merge_requests.merge_error not found",
+		"column merge_requests.merge_status has type integer, expected character varying or text",
+	}, schemaProblems(map[string]string{"target_project_id": "bigint", "iid": "integer", "merge_status": "integer"}))
+}
+
+// TestCheckCompatibility tests that an incompatible gitlab switches the db
+// enforcer to read-only mode, or is refused, and is not checked when the
+// db enforcer is not used.
+func TestCheckCompatibility(t *testing.T) {
+	calls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/version", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"version": "18.1.0-ee", "revision": "abc"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{
+		Config: conf.Config{Projects: []conf.ApprovRule{{ProjectId: 3}}},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+
+	assert.NoError(t, s.CheckCompatibility(context.Background()))
+	assert.True(t, s.dbReadOnly())
+	unchecked, problems := s.compat.get()
+	assert.Empty(t, unchecked)
+	assert.Equal(t, []string{"gitlab version 18.1.0-ee is not supported, only 16.0 to 17.x are", "psql_conn_url is not defined"}, problems)
+	// nothing is written in read-only mode
+	assert.NoError(t, s.updateMergeStatus(context.Background(), 3, 7, statusCanBeMerged, ""))
+
+	s.Config.DBIncompatible = conf.DBIncompatibleRefuse
+	assert.ErrorContains(t, s.CheckCompatibility(context.Background()), "gitlab version 18.1.0-ee is not supported")
+
+	s = &Service{
+		Config: conf.Config{Enforcer: conf.EnforcerCommitStatus, Projects: []conf.ApprovRule{{ProjectId: 3}}},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+	assert.NoError(t, s.CheckCompatibility(context.Background()))
+	assert.False(t, s.dbReadOnly())
+	assert.Equal(t, 2, calls)
+}
+
+// TestCheckCompatibilityUnchecked tests that a gitlab failure is not an
+// incompatibility, even with "refuse": the db enforcer stays in read-only
+// mode until a check completes.
+func TestCheckCompatibilityUnchecked(t *testing.T) {
+	up := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/version", func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version": "17.2.1-ee", "revision": "abc"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{
+		Config: conf.Config{DBIncompatible: conf.DBIncompatibleRefuse, Projects: []conf.ApprovRule{{ProjectId: 3}}},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+	s.gitlab.Retries = 0
+
+	assert.NoError(t, s.CheckCompatibility(context.Background()))
+	assert.True(t, s.dbReadOnly())
+	unchecked, problems := s.compat.get()
+	assert.Contains(t, unchecked, "failed reading gitlab version")
+	assert.Empty(t, problems)
+
+	up = true
+	assert.ErrorContains(t, s.CheckCompatibility(context.Background()), "psql_conn_url is not defined")
+	unchecked, problems = s.compat.get()
+	assert.Empty(t, unchecked)
+	assert.Equal(t, []string{"psql_conn_url is not defined"}, problems)
+
+	s.compat.set("", nil)
+	assert.False(t, s.dbReadOnly())
+}
@@ -0,0 +1,178 @@
+//
+// enforcer.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/rs/zerolog/log"
+)
+
+// commitStatusName is the default name of the commit status posted by the
+// commit_status enforcer.
+const commitStatusName = "mergesentinel/approvals"
+
+// maxDescription is the longest commit status description gitlab accepts.
+const maxDescription = 255
+
+// truncate shortens text to max characters, marking the cut with "...".
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// Enforcer applies the evaluation of a MR in gitlab, allowing or blocking
+// its merge.
+type Enforcer interface {
+	// Enforce applies ev to mr. mr has at least ProjectID and Iid set.
+	Enforce(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) error
+	// Drifted reports if the state applied in gitlab for mr differs from ev.
+	Drifted(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) (bool, error)
+}
+
+// dbEnforcer writes merge_status/merge_error in the gitlab merge_requests table.
+type dbEnforcer struct {
+	s *Service
+}
+
+func (e dbEnforcer) Enforce(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) error {
+	return e.s.updateMergeStatus(ctx, mr.ProjectID, mr.Iid, ev.Status(), ev.Message())
+}
+
+func (e dbEnforcer) Drifted(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) (bool, error) {
+	return mr.MergeStatus != ev.Status() || mr.MergeError != ev.Message(), nil
+}
+
+// commitStatusEnforcer posts a commit status on the MR head commit, only
+// using the gitlab API. The state is success when the MR can be merged,
+// pending while it only waits for approvals, failed otherwise. It blocks
+// the merge when the project requires pipelines to succeed.
+type commitStatusEnforcer struct {
+	s *Service
+}
+
+// commitState returns the commit status state and description of ev.
+func commitState(ev Evaluation) (string, string) {
+	switch {
+	case ev.WaitingApprovals():
+		return "pending", "Missing approvals: " + ev.MissingApprovals()
+	case !ev.Mergeable():
+		return "failed", ev.Message()
+	}
+	return "success", "Merge rules satisfied"
+}
+
+func (e commitStatusEnforcer) name() string {
+	if e.s.Config.CommitStatusName != "" {
+		return e.s.Config.CommitStatusName
+	}
+	return commitStatusName
+}
+
+func (e commitStatusEnforcer) Enforce(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) error {
+	if mr.Sha == "" {
+		var err error
+		if mr, err = e.s.gitlab.MergeRequest(ctx, mr.ProjectID, mr.Iid); err != nil {
+			return err
+		}
+	}
+	state, description := commitState(ev)
+	return e.s.gitlab.SetCommitStatus(ctx, mr.ProjectID, mr.Sha, gitlab.CommitStatusOptions{
+		State:       state,
+		Name:        e.name(),
+		Description: truncate(description, maxDescription),
+		TargetURL:   mr.WebURL,
+	})
+}
+
+func (e commitStatusEnforcer) Drifted(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) (bool, error) {
+	// only the latest status of each name is listed
+	statuses, err := e.s.gitlab.CommitStatuses(ctx, mr.ProjectID, mr.Sha, e.name())
+	if err != nil {
+		return false, err
+	}
+	state, description := commitState(ev)
+	for _, st := range statuses {
+		if st.Name == e.name() {
+			return st.Status != state || st.Description != truncate(description, maxDescription), nil
+		}
+	}
+	return true, nil
+}
+
+// statusCheckEnforcer answers the MergeSentinel external status check of
+// the project, only using the gitlab API.
+type statusCheckEnforcer struct {
+	s *Service
+}
+
+// statusCheckStatus returns the external status check status of ev.
+func statusCheckStatus(ev Evaluation) string {
+	if ev.Mergeable() {
+		return "passed"
+	}
+	return "failed"
+}
+
+func (e statusCheckEnforcer) Enforce(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) error {
+	if mr.Sha == "" {
+		var err error
+		if mr, err = e.s.gitlab.MergeRequest(ctx, mr.ProjectID, mr.Iid); err != nil {
+			return err
+		}
+	}
+	check_id, err := e.s.statusCheckID(ctx, mr.ProjectID)
+	if err != nil {
+		return err
+	}
+	return e.s.gitlab.SetStatusCheckResponse(ctx, mr.ProjectID, mr.Iid, mr.Sha, check_id, statusCheckStatus(ev))
+}
+
+func (e statusCheckEnforcer) Drifted(ctx context.Context, mr gitlab.MergeRequest, ev Evaluation) (bool, error) {
+	check_id, err := e.s.statusCheckID(ctx, mr.ProjectID)
+	if err != nil {
+		return false, err
+	}
+	checks, err := e.s.gitlab.StatusChecks(ctx, mr.ProjectID, mr.Iid)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range checks {
+		if c.ID == check_id {
+			return c.Status != statusCheckStatus(ev), nil
+		}
+	}
+	return true, nil
+}
+
+// enforcer returns the enforcer configured for the project.
+func (s *Service) enforcer(p conf.ApprovRule) Enforcer {
+	switch s.Config.ProjectEnforcer(p) {
+	case conf.EnforcerCommitStatus:
+		return commitStatusEnforcer{s: s}
+	case conf.EnforcerStatusCheck:
+		return statusCheckEnforcer{s: s}
+	default:
+		return dbEnforcer{s: s}
+	}
+}
+
+// enforce applies the evaluation of the MR with the project enforcer.
+func (s *Service) enforce(ctx context.Context, p conf.ApprovRule, mr gitlab.MergeRequest, ev Evaluation) error {
+	mr.ProjectID = p.ProjectId
+	err := s.enforcer(p).Enforce(ctx, mr, ev)
+	if err != nil {
+		log.Err(err).Int("project_id", p.ProjectId).Int("mr", mr.Iid).Str("enforcer", s.Config.ProjectEnforcer(p)).Msg("failed enforcing MR rule")
+	}
+	return err
+}
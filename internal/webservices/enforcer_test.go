@@ -0,0 +1,66 @@
+//
+// enforcer_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCommitStatusEnforcer tests the commit status posted for an evaluation.
+func TestCommitStatusEnforcer(t *testing.T) {
+	var posted map[string]string
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "glpat-token", r.Header.Get("PRIVATE-TOKEN"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&posted))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	p := conf.ApprovRule{ProjectId: 3, Enforcer: conf.EnforcerCommitStatus}
+	s := &Service{Config: conf.Config{GitlabURL: srv.URL, GitlabToken: "glpat-token"}, gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client())}
+	assert.IsType(t, commitStatusEnforcer{}, s.enforcer(p))
+
+	err := s.enforce(context.Background(), p, gitlab.MergeRequest{Iid: 7, Sha: "abc"}, Evaluation{Failures: []string{"MR is a draft"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/v4/projects/3/statuses/abc", path)
+	assert.Equal(t, map[string]string{"state": "failed", "name": commitStatusName, "description": "MR is a draft"}, posted)
+
+	err = s.enforce(context.Background(), p, gitlab.MergeRequest{Iid: 7, Sha: "abc", WebURL: "https://gitlab.example.com/mr/7"}, Evaluation{})
+	assert.NoError(t, err)
+	assert.Equal(t, "success", posted["state"])
+	assert.Equal(t, "https://gitlab.example.com/mr/7", posted["target_url"])
+
+	ev := Evaluation{Groups: []GroupStatus{
+		{Name: "backend", Required: 2, Approvers: []string{"alice", "bob", "carol"}, ApprovedBy: []string{"bob"}},
+	}}
+	s.Config.CommitStatusName = "sentinel"
+	err = s.enforce(context.Background(), p, gitlab.MergeRequest{Iid: 7, Sha: "abc"}, ev)
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", posted["state"])
+	assert.Equal(t, "sentinel", posted["name"])
+	assert.Equal(t, "Missing approvals: backend: 1 more from [alice carol]", posted["description"])
+}
+
+// TestTruncate tests the description truncation.
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "ééééééé...", truncate(strings.Repeat("é", 20), 10))
+}
@@ -0,0 +1,132 @@
+//
+// freeze.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/rs/zerolog/log"
+)
+
+// freezeCheckInterval is how often the freeze windows are checked for
+// opening or closing.
+const freezeCheckInterval = time.Minute
+
+// protectedMatch reports if branch matches a gitlab protected branch name,
+// where "*" matches any sequence of characters.
+func protectedMatch(name string, branch string) bool {
+	if !strings.Contains(name, "*") {
+		return name == branch
+	}
+	parts := strings.Split(name, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	matched, _ := regexp.MatchString("^"+strings.Join(parts, ".*")+"$", branch)
+	return matched
+}
+
+// protectedBranch reports if branch is protected in the gitlab project.
+func (s *Service) protectedBranch(ctx context.Context, project_id int, branch string) (bool, error) {
+	protected, err := s.gitlab.ProtectedBranches(ctx, project_id)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range protected {
+		if protectedMatch(p.Name, branch) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// freezeFailures returns a failure for every freeze window open at now that
+// blocks the MR.
+func (s *Service) freezeFailures(ctx context.Context, project_id int, mr gitlab.MergeRequest, labels []string, now time.Time) ([]string, error) {
+	var failures []string
+	protected := -1
+	for _, f := range s.Config.Freezes {
+		if !f.AppliesTo(project_id) || f.Window == nil {
+			continue
+		}
+		until, open := f.Window.ActiveUntil(now)
+		if !open || f.Exempts(labels) {
+			continue
+		}
+		frozen := false
+		if len(f.Branches) > 0 {
+			for _, b := range f.Branches {
+				if matched, _ := path.Match(b, mr.TargetBranch); matched {
+					frozen = true
+					break
+				}
+			}
+		} else {
+			if protected < 0 {
+				isProtected, err := s.protectedBranch(ctx, project_id, mr.TargetBranch)
+				if err != nil {
+					return nil, err
+				}
+				protected = 0
+				if isProtected {
+					protected = 1
+				}
+			}
+			frozen = protected == 1
+		}
+		if frozen {
+			failures = append(failures, fmt.Sprintf("Merge freeze '%s' in effect until %s", f.Name, until.Format("2006-01-02 15:04 MST")))
+		}
+	}
+	return failures, nil
+}
+
+// activeFreezes returns the names of the freeze windows open at t.
+func activeFreezes(freezes []conf.FreezeWindow, t time.Time) string {
+	var names []string
+	for _, f := range freezes {
+		if f.Window != nil && f.Window.Active(t) {
+			names = append(names, f.Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// WatchFreezes re-evaluates every open MR when a freeze window opens or
+// closes. It returns when ctx is done.
+func (s *Service) WatchFreezes(ctx context.Context) {
+	if len(s.Config.Freezes) == 0 {
+		return
+	}
+	active := activeFreezes(s.Config.Freezes, time.Now())
+	ticker := time.NewTicker(freezeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current := activeFreezes(s.Config.Freezes, now)
+			if current == active {
+				continue
+			}
+			log.Info().Str("previous", active).Str("current", current).Msg("freeze windows changed")
+			active = current
+			if err := s.ReinforceAllMrRule(ctx); err != nil {
+				log.Err(err).Msg("failed reinforcing MR rules after freeze change")
+			}
+		}
+	}
+}
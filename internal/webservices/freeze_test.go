@@ -0,0 +1,56 @@
+//
+// freeze_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/freeze"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProtectedMatch tests gitlab protected branch wildcards.
+func TestProtectedMatch(t *testing.T) {
+	assert.True(t, protectedMatch("main", "main"))
+	assert.False(t, protectedMatch("main", "main2"))
+	assert.True(t, protectedMatch("release/*", "release/v1/hotfix"))
+	assert.True(t, protectedMatch("*-stable", "16-0-stable"))
+	assert.False(t, protectedMatch("release/*", "feature/release"))
+}
+
+// TestFreezeFailures tests the freeze windows blocking a MR.
+func TestFreezeFailures(t *testing.T) {
+	holidays, err := freeze.New("", "", "2024-12-20 17:00", "2025-01-06 09:00", "")
+	assert.NoError(t, err)
+	s := &Service{Config: conf.Config{Freezes: []conf.FreezeWindow{
+		{Name: "holidays", Branches: []string{"main", "release/*"}, ExemptLabels: []string{"hotfix"}, Window: holidays},
+		{Name: "other project", Branches: []string{"main"}, Projects: []int{2}, Window: holidays},
+	}}}
+	during := time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC)
+	mr := gitlab.MergeRequest{TargetBranch: "main"}
+
+	failures, err := s.freezeFailures(context.Background(), 1, mr, nil, during)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Merge freeze 'holidays' in effect until 2025-01-06 09:00 UTC"}, failures)
+
+	failures, _ = s.freezeFailures(context.Background(), 1, mr, []string{"hotfix"}, during)
+	assert.Empty(t, failures)
+
+	failures, _ = s.freezeFailures(context.Background(), 1, gitlab.MergeRequest{TargetBranch: "feature/x"}, nil, during)
+	assert.Empty(t, failures)
+
+	failures, _ = s.freezeFailures(context.Background(), 1, mr, nil, during.AddDate(0, 1, 0))
+	assert.Empty(t, failures)
+
+	assert.Equal(t, "holidays,other project", activeFreezes(s.Config.Freezes, during))
+	assert.Equal(t, "", activeFreezes(s.Config.Freezes, during.AddDate(0, 1, 0)))
+}
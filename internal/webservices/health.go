@@ -0,0 +1,180 @@
+//
+// health.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/rs/zerolog/log"
+)
+
+// The status of a check and of the readiness.
+const (
+	checkOK      = "ok"
+	checkFail    = "fail"
+	checkSkipped = "skipped"
+)
+
+// CheckResult is the result of a readiness check.
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Detail any    `json:"detail,omitempty"`
+}
+
+// Readiness is the reply of the Readyz handler: ok if every check is ok or
+// skipped.
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// readinessChecks are the checks of the Readyz handler, by name.
+var readinessChecks = map[string]func(*Service, context.Context) CheckResult{
+	"enforcers": (*Service).checkEnforcers,
+	"gitlab":    (*Service).checkGitlab,
+	"database":  (*Service).checkDatabase,
+	"queue":     (*Service).checkQueue,
+}
+
+// checkEnforcers checks that the enforcers of the projects can apply the
+// evaluations: the db enforcer compatibility checked and passed, and the
+// external status checks registered.
+func (s *Service) checkEnforcers(ctx context.Context) CheckResult {
+	var failures []string
+	if s.usesDBEnforcer() {
+		unchecked, problems := s.compat.get()
+		if unchecked != "" {
+			failures = append(failures, "db enforcer compatibility not checked: "+unchecked)
+		}
+		for _, p := range problems {
+			failures = append(failures, "db enforcer incompatible: "+p)
+		}
+	}
+	if failure := s.statusChecks.getFailure(); failure != "" {
+		failures = append(failures, "external status checks not registered: "+failure)
+	}
+	if len(failures) > 0 {
+		return CheckResult{Status: checkFail, Error: strings.Join(failures, "; ")}
+	}
+	return CheckResult{Status: checkOK}
+}
+
+// checkGitlab checks that the gitlab API is reachable and accepts the token.
+func (s *Service) checkGitlab(ctx context.Context) CheckResult {
+	if s.gitlab == nil {
+		return CheckResult{Status: checkFail, Error: "gitlab client not configured"}
+	}
+	version, err := s.gitlab.Version(ctx)
+	if errors.Is(err, gitlab.ErrUnauthorized) {
+		return CheckResult{Status: checkFail, Error: "invalid gitlab token: " + err.Error()}
+	}
+	if err != nil {
+		return CheckResult{Status: checkFail, Error: err.Error()}
+	}
+	return CheckResult{Status: checkOK, Detail: version}
+}
+
+// checkDatabase checks, if the db enforcer is used, that the database is
+// reachable.
+func (s *Service) checkDatabase(ctx context.Context) CheckResult {
+	if !s.usesDBEnforcer() {
+		return CheckResult{Status: checkSkipped}
+	}
+	if s.db == nil {
+		return CheckResult{Status: checkFail, Error: "psql_conn_url is not defined"}
+	}
+	health := s.db.Health(ctx)
+	if !health.Up {
+		return CheckResult{Status: checkFail, Error: health.Error, Detail: health}
+	}
+	return CheckResult{Status: checkOK, Detail: health}
+}
+
+// checkQueue checks that the webhook events waiting to be processed are at
+// most ready_max_backlog.
+func (s *Service) checkQueue(ctx context.Context) CheckResult {
+	if s.queue == nil {
+		return CheckResult{Status: checkSkipped}
+	}
+	max := s.Config.ReadyMaxBacklog
+	if max == 0 {
+		max = conf.DefaultReadyMaxBacklog
+	}
+	backlog := s.queue.Len()
+	detail := map[string]int{"backlog": backlog, "max": max}
+	if backlog > max {
+		return CheckResult{Status: checkFail, Error: fmt.Sprintf("%d webhook events waiting, more than %d", backlog, max), Detail: detail}
+	}
+	return CheckResult{Status: checkOK, Detail: detail}
+}
+
+// readiness runs every readiness check, concurrently.
+func (s *Service) readiness(ctx context.Context) Readiness {
+	ctx, cancel := context.WithTimeout(ctx, stateTimeout)
+	defer cancel()
+	ready := Readiness{Status: checkOK, Checks: make(map[string]CheckResult, len(readinessChecks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := check(s, ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			ready.Checks[name] = res
+			if res.Status == checkFail {
+				ready.Status = checkFail
+			}
+		}()
+	}
+	wg.Wait()
+	return ready
+}
+
+// Livez replies 200 while the service is running. It checks no dependency,
+// so an unreachable gitlab or database never gets the service restarted.
+func (s *Service) Livez(w http.ResponseWriter, r *http.Request) {
+	replyJSON(w, http.StatusOK, map[string]string{"status": checkOK})
+}
+
+// Readyz checks that the service can process MRs: enforcers usable, gitlab
+// API reachable with a valid token, database reachable if the db enforcer is
+// used and webhook backlog not too long. It replies 200, or 503 if any check
+// failed, with the result of every check.
+func (s *Service) Readyz(w http.ResponseWriter, r *http.Request) {
+	ready := s.readiness(r.Context())
+	code := http.StatusOK
+	if ready.Status != checkOK {
+		for name, res := range ready.Checks {
+			if res.Status == checkFail {
+				log.Warn().Str("check", name).Str("error", res.Error).Msg("not ready")
+			}
+		}
+		code = http.StatusServiceUnavailable
+	}
+	replyJSON(w, code, ready)
+}
+
+// replyJSON replies v encoded in JSON with the status code.
+func replyJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Err(err).Send()
+	}
+}
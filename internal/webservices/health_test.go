@@ -0,0 +1,120 @@
+//
+// health_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/cropalato/MergeSentinel/internal/queue"
+	"github.com/cropalato/MergeSentinel/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// readyz calls the Readyz handler of s and returns the status code and reply.
+func readyz(t *testing.T, s *Service) (int, Readiness) {
+	w := httptest.NewRecorder()
+	s.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var ready Readiness
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ready))
+	return w.Code, ready
+}
+
+// TestLivez tests that the liveness does not depend on the checks.
+func TestLivez(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Service{}).Livez(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+// TestReadyz tests the result of every readiness check, and 503 when one
+// fails.
+func TestReadyz(t *testing.T) {
+	token := "glpat-token"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/version", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"version": "17.2.1-ee", "revision": "abc"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	events, err := queue.Open("")
+	assert.NoError(t, err)
+	defer events.Close()
+	s := &Service{
+		Config: conf.Config{
+			Enforcer:        conf.EnforcerCommitStatus,
+			Projects:        []conf.ApprovRule{{ProjectId: 3}},
+			ReadyMaxBacklog: 1,
+		},
+		gitlab: gitlab.New(srv.URL, token, srv.Client()),
+		queue:  events,
+	}
+
+	code, ready := readyz(t, s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", ready.Status)
+	assert.Equal(t, "ok", ready.Checks["enforcers"].Status)
+	assert.Equal(t, "ok", ready.Checks["gitlab"].Status)
+	assert.Equal(t, "skipped", ready.Checks["database"].Status)
+	assert.Equal(t, "ok", ready.Checks["queue"].Status)
+
+	// backlog longer than ready_max_backlog
+	_, err = events.Push([]byte("{}"))
+	assert.NoError(t, err)
+	_, err = events.Push([]byte("{}"))
+	assert.NoError(t, err)
+	code, ready = readyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", ready.Status)
+	assert.Equal(t, "fail", ready.Checks["queue"].Status)
+	assert.Equal(t, "ok", ready.Checks["gitlab"].Status)
+	s.Config.ReadyMaxBacklog = 0
+
+	// invalid token
+	s.gitlab = gitlab.New(srv.URL, "glpat-revoked", srv.Client())
+	s.gitlab.Retries = 0
+	code, ready = readyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "ok", ready.Checks["queue"].Status)
+	assert.Equal(t, "fail", ready.Checks["gitlab"].Status)
+	assert.Contains(t, ready.Checks["gitlab"].Error, "invalid gitlab token")
+	s.gitlab = gitlab.New(srv.URL, token, srv.Client())
+
+	// db enforcer with the database unreachable
+	db, err := store.Open("postgres://user@127.0.0.1:1/gitlabhq_production?sslmode=disable&connect_timeout=1", poolOptions(conf.Config{}))
+	assert.NoError(t, err)
+	defer db.Close()
+	s.Config.Enforcer = conf.EnforcerDB
+	s.db = db
+	code, ready = readyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", ready.Checks["database"].Status)
+	assert.NotEmpty(t, ready.Checks["database"].Error)
+	assert.Equal(t, "ok", ready.Checks["enforcers"].Status)
+
+	// db enforcer compatibility not checked, status checks not registered
+	s.compat.set("failed reading gitlab version", nil)
+	s.statusChecks.setFailure("gitlab: unauthorized")
+	_, ready = readyz(t, s)
+	assert.Equal(t, "fail", ready.Checks["enforcers"].Status)
+	assert.Equal(t, "db enforcer compatibility not checked: failed reading gitlab version; external status checks not registered: gitlab: unauthorized", ready.Checks["enforcers"].Error)
+
+	// gitlab client missing
+	code, ready = readyz(t, &Service{})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", ready.Checks["gitlab"].Status)
+}
@@ -0,0 +1,116 @@
+//
+// members.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+type memberCacheEntry struct {
+	usernames []string
+	expires   time.Time
+}
+
+// memberCache keeps the usernames of gitlab groups for ttl.
+type memberCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]memberCacheEntry
+}
+
+func newMemberCache(ttl time.Duration) *memberCache {
+	return &memberCache{ttl: ttl, entries: map[string]memberCacheEntry{}}
+}
+
+// get returns the cached usernames of group. fresh is false when the entry
+// is expired, but the stale value is still returned if one exists.
+func (c *memberCache) get(group string) (usernames []string, found bool, fresh bool) {
+	if c == nil {
+		return nil, false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[group]
+	return e.usernames, found, found && time.Now().Before(e.expires)
+}
+
+func (c *memberCache) set(group string, usernames []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[group] = memberCacheEntry{usernames: usernames, expires: time.Now().Add(c.ttl)}
+}
+
+// fetchGroupMembers lists all active members of the gitlab group, including
+// inherited ones.
+func (s *Service) fetchGroupMembers(ctx context.Context, group string) ([]string, error) {
+	members, err := s.gitlab.GroupMembers(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	var usernames []string
+	for _, m := range members {
+		if m.State == "active" {
+			usernames = append(usernames, m.Username)
+		}
+	}
+	return usernames, nil
+}
+
+// groupMembers returns the usernames of the gitlab group, using the cache
+// when possible. A stale cache entry is used if gitlab can not be reached.
+func (s *Service) groupMembers(ctx context.Context, group string) ([]string, error) {
+	cached, found, fresh := s.members.get(group)
+	if fresh {
+		return cached, nil
+	}
+	usernames, err := s.fetchGroupMembers(ctx, group)
+	if err != nil {
+		if found {
+			log.Warn().Err(err).Str("group", group).Msg("failed refreshing group members, using cached value")
+			return cached, nil
+		}
+		return nil, err
+	}
+	log.Debug().Str("group", group).Strs("members", usernames).Msg("group members loaded")
+	s.members.set(group, usernames)
+	return usernames, nil
+}
+
+// resolveGroupApprovers maps every group entry ("@<group path>") found in
+// the approver groups to the usernames of its members. It fails if a group
+// can not be resolved, so the evaluation is retried instead of missing its
+// approvers.
+func (s *Service) resolveGroupApprovers(ctx context.Context, groups []conf.ApproverGroup) (map[string][]string, error) {
+	resolved := map[string][]string{}
+	for _, grp := range groups {
+		for _, a := range grp.Approvals {
+			if !strings.HasPrefix(a, conf.GroupPrefix) {
+				continue
+			}
+			if _, ok := resolved[a]; ok {
+				continue
+			}
+			usernames, err := s.groupMembers(ctx, strings.TrimPrefix(a, conf.GroupPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("failed resolving approver group '%s': %w", a, err)
+			}
+			resolved[a] = usernames
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,45 @@
+//
+// members_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResolveGroupApprovers tests that a group whose members can not be
+// loaded fails the resolution, as an upstream error, instead of leaving the
+// group without members.
+func TestResolveGroupApprovers(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/groups/backend/members/all", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"username": "alice", "state": "active"}, {"username": "bob", "state": "blocked"}]`))
+	})
+	mux.HandleFunc("GET /api/v4/groups/security/members/all", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client())}
+	s.gitlab.Retries = 0
+
+	resolved, err := s.resolveGroupApprovers(context.Background(), []conf.ApproverGroup{{Approvals: []string{"carol", "@backend"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"@backend": {"alice"}}, resolved)
+
+	resolved, err = s.resolveGroupApprovers(context.Background(), []conf.ApproverGroup{{Approvals: []string{"@backend", "@security"}}})
+	assert.Nil(t, resolved)
+	assert.ErrorIs(t, err, apperr.ErrUpstream)
+}
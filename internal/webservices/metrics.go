@@ -0,0 +1,44 @@
+//
+// metrics.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/rs/zerolog/log"
+)
+
+// The metrics are published with expvar, served as json on /debug/vars.
+var (
+	// errorCounts counts the failures by kind: config, upstream, database,
+	// policy or unknown.
+	errorCounts = expvar.NewMap("errors")
+	// webhookEvents counts the webhook events by outcome: queued,
+	// processed, retried or dropped.
+	webhookEvents = expvar.NewMap("webhook_events")
+	// reconcileCounts counts, since the start, the reconciliation passes,
+	// the MRs checked and the drifts corrected.
+	reconcileCounts = expvar.NewMap("reconcile")
+)
+
+// recordError counts err, if not nil, in the metrics of its kind.
+func recordError(err error) {
+	if err != nil {
+		errorCounts.Add(apperr.KindOf(err).String(), 1)
+	}
+}
+
+// replyError replies to a failed request with the http status of the kind
+// of err, and counts it.
+func replyError(w http.ResponseWriter, err error) {
+	recordError(err)
+	log.Debug().Err(err).Str("kind", apperr.KindOf(err).String()).Msg("request failed")
+	http.Error(w, err.Error(), apperr.HTTPStatus(err))
+}
@@ -0,0 +1,279 @@
+//
+// policy.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+)
+
+const (
+	statusCanBeMerged    = "can_be_merged"
+	statusCannotBeMerged = "cannot_be_merged"
+)
+
+// GroupStatus is the approval state of one approver group.
+type GroupStatus struct {
+	Name       string
+	Required   int
+	Approvers  []string
+	ApprovedBy []string
+}
+
+// Missing returns how many approvals the group still needs.
+func (g GroupStatus) Missing() int {
+	if m := g.Required - len(g.ApprovedBy); m > 0 {
+		return m
+	}
+	return 0
+}
+
+// DiscardedApproval is an approval from a listed approver that does not
+// count toward any quorum.
+type DiscardedApproval struct {
+	Username string
+	Reason   string
+}
+
+// Pending returns the approvers of the group who did not approve yet.
+func (g GroupStatus) Pending() []string {
+	var pending []string
+	for _, a := range g.Approvers {
+		approved := false
+		for _, by := range g.ApprovedBy {
+			if a == by {
+				approved = true
+				break
+			}
+		}
+		if !approved {
+			pending = append(pending, a)
+		}
+	}
+	return pending
+}
+
+// Evaluation is the result of checking a MR against its approval rule.
+type Evaluation struct {
+	Groups    []GroupStatus
+	Failures  []string
+	Discarded []DiscardedApproval
+}
+
+// Mergeable reports if every group reached its quorum and no other
+// condition failed.
+func (e Evaluation) Mergeable() bool {
+	if len(e.Failures) > 0 {
+		return false
+	}
+	for _, g := range e.Groups {
+		if g.Missing() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitingApprovals reports if the MR is only blocked by missing approvals.
+func (e Evaluation) WaitingApprovals() bool {
+	return len(e.Failures) == 0 && !e.Mergeable()
+}
+
+// MissingApprovals describes the approvals still needed by every group
+// short of its quorum.
+func (e Evaluation) MissingApprovals() string {
+	var msgs []string
+	for _, g := range e.Groups {
+		missing := g.Missing()
+		if missing == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("%d more from %v", missing, g.Pending())
+		if g.Name != "" {
+			msg = g.Name + ": " + msg
+		}
+		msgs = append(msgs, msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Status returns the merge_status value matching the evaluation.
+func (e Evaluation) Status() string {
+	if e.Mergeable() {
+		return statusCanBeMerged
+	}
+	return statusCannotBeMerged
+}
+
+// Message returns the merge_error text explaining why the MR is blocked.
+// It is empty when the MR can be merged.
+func (e Evaluation) Message() string {
+	msgs := append([]string{}, e.Failures...)
+	for _, g := range e.Groups {
+		missing := g.Missing()
+		if missing == 0 {
+			continue
+		}
+		if g.Name == "" {
+			msgs = append(msgs, fmt.Sprintf("Requires at least %d approvals from %v", g.Required, g.Approvers))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("Group '%s' needs %d more approval(s) (%d required from %v)", g.Name, missing, g.Required, g.Approvers))
+		}
+	}
+	if len(msgs) == 0 {
+		return ""
+	}
+	for _, d := range e.Discarded {
+		msgs = append(msgs, fmt.Sprintf("Approval from '%s' discarded: %s", d.Username, d.Reason))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// isApprover reports if username is one of the approvals entries, either
+// directly or as member of a referenced gitlab group.
+func isApprover(username string, approvals []string, members map[string][]string) bool {
+	for _, a := range approvals {
+		if a == username {
+			return true
+		}
+		if strings.HasPrefix(a, conf.GroupPrefix) {
+			for _, m := range members[a] {
+				if m == username {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// evaluateGroups counts, for every group, the approvers found in approvedBy.
+// members holds the usernames of the gitlab groups referenced in the
+// approvals. The same user can count toward several groups. Approvals from
+// users in excluded never count; the map value is the reason reported.
+func evaluateGroups(groups []conf.ApproverGroup, approvedBy []string, members map[string][]string, excluded map[string]string) Evaluation {
+	var ev Evaluation
+	discarded := map[string]bool{}
+	for _, grp := range groups {
+		gs := GroupStatus{Name: grp.Name, Required: grp.MinApprov, Approvers: grp.Approvals}
+		for _, by := range approvedBy {
+			if !isApprover(by, grp.Approvals, members) {
+				continue
+			}
+			if reason, ok := excluded[by]; ok {
+				if !discarded[by] {
+					discarded[by] = true
+					ev.Discarded = append(ev.Discarded, DiscardedApproval{Username: by, Reason: reason})
+				}
+				continue
+			}
+			gs.ApprovedBy = append(gs.ApprovedBy, by)
+		}
+		ev.Groups = append(ev.Groups, gs)
+	}
+	return ev
+}
+
+// conditionFailures returns a message for every non approval condition of
+// the rule the MR does not satisfy.
+func conditionFailures(rule conf.BranchRule, mr gitlab.MergeRequest) []string {
+	var failures []string
+	if rule.BlockDraft && (mr.Draft || mr.WorkInProgress) {
+		failures = append(failures, "MR is a draft")
+	}
+	if rule.BlockConflicts && mr.HasConflicts {
+		failures = append(failures, "MR has conflicts with the target branch")
+	}
+	if rule.BlockDiscussions && !mr.BlockingDiscussionsResolved {
+		failures = append(failures, "MR has unresolved blocking discussions")
+	}
+	return failures
+}
+
+// pipelineFailures returns a message when the MR head pipeline did not
+// succeed on the MR head commit.
+func pipelineFailures(mr gitlab.MergeRequest) []string {
+	p := mr.HeadPipeline
+	switch {
+	case p == nil || p.Sha != mr.Sha:
+		return []string{"No pipeline found for the MR head commit"}
+	case p.Status != "success":
+		return []string{fmt.Sprintf("Head pipeline #%d is %s", p.ID, p.Status)}
+	}
+	return nil
+}
+
+// labelNames returns the names of the MR labels. The MR API returns the
+// label names while the webhooks return label objects.
+func labelNames(labels []interface{}) []string {
+	var names []string
+	for _, l := range labels {
+		switch v := l.(type) {
+		case string:
+			names = append(names, v)
+		case map[string]interface{}:
+			if title, ok := v["title"].(string); ok {
+				names = append(names, title)
+			} else if name, ok := v["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// applyLabels evaluates the label rules for the MR labels. It returns the
+// approver groups updated by the bypass labels, a failure for every
+// blocking label and the bypass labels applied.
+func applyLabels(rules []conf.LabelRule, labels []string, groups []conf.ApproverGroup) ([]conf.ApproverGroup, []string, []conf.LabelRule) {
+	var failures []string
+	var bypasses []conf.LabelRule
+	min := 0
+	for _, lr := range rules {
+		found := false
+		for _, l := range labels {
+			if l == lr.Label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		if lr.Block {
+			failures = append(failures, fmt.Sprintf("Label '%s' blocks the merge", lr.Label))
+			continue
+		}
+		if !lr.IsBypass() {
+			continue
+		}
+		bypasses = append(bypasses, lr)
+		if min == 0 || lr.MinApprov < min {
+			min = lr.MinApprov
+		}
+	}
+	if len(bypasses) == 0 {
+		return groups, failures, nil
+	}
+	updated := make([]conf.ApproverGroup, 0, len(groups)+len(bypasses))
+	for _, g := range groups {
+		if g.MinApprov > min {
+			g.MinApprov = min
+		}
+		updated = append(updated, g)
+	}
+	for _, lr := range bypasses {
+		if len(lr.Approvals) > 0 {
+			updated = append(updated, conf.ApproverGroup{Name: "label " + lr.Label, Approvals: lr.Approvals, MinApprov: 1})
+		}
+	}
+	return updated, failures, bypasses
+}
@@ -0,0 +1,162 @@
+//
+// policy_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEvaluateGroups tests the quorum computation for several approver groups.
+func TestEvaluateGroups(t *testing.T) {
+	groups := []conf.ApproverGroup{
+		{Name: "backend", Approvals: []string{"alice", "bob", "carol"}, MinApprov: 2},
+		{Name: "security", Approvals: []string{"sec1", "carol"}, MinApprov: 1},
+	}
+
+	t.Run("Every quorum met", func(t *testing.T) {
+		ev := evaluateGroups(groups, []string{"alice", "carol"}, nil, nil)
+		assert.True(t, ev.Mergeable())
+		assert.Equal(t, statusCanBeMerged, ev.Status())
+		assert.Empty(t, ev.Message())
+	})
+
+	t.Run("One group short", func(t *testing.T) {
+		ev := evaluateGroups(groups, []string{"alice", "sec1", "someone"}, nil, nil)
+		assert.False(t, ev.Mergeable())
+		assert.Equal(t, statusCannotBeMerged, ev.Status())
+		assert.Equal(t, "Group 'backend' needs 1 more approval(s) (2 required from [alice bob carol])", ev.Message())
+	})
+
+	t.Run("Unnamed group keeps legacy message", func(t *testing.T) {
+		ev := evaluateGroups([]conf.ApproverGroup{{Approvals: []string{"user1", "user7"}, MinApprov: 2}}, nil, nil, nil)
+		assert.Equal(t, "Requires at least 2 approvals from [user1 user7]", ev.Message())
+	})
+
+	t.Run("Failures block the merge", func(t *testing.T) {
+		ev := evaluateGroups(groups, []string{"alice", "carol"}, nil, nil)
+		ev.Failures = append(ev.Failures, "blocked")
+		assert.False(t, ev.Mergeable())
+		assert.Equal(t, "blocked", ev.Message())
+	})
+}
+
+// TestEvaluateGroupsWithGitlabGroups tests approvers resolved from gitlab groups.
+func TestEvaluateGroupsWithGitlabGroups(t *testing.T) {
+	groups := []conf.ApproverGroup{
+		{Name: "backend", Approvals: []string{"@org/backend-leads", "bob"}, MinApprov: 2},
+	}
+	members := map[string][]string{"@org/backend-leads": {"alice", "dave"}}
+
+	ev := evaluateGroups(groups, []string{"alice", "bob"}, members, nil)
+	assert.True(t, ev.Mergeable())
+
+	ev = evaluateGroups(groups, []string{"alice", "eve"}, members, nil)
+	assert.False(t, ev.Mergeable())
+	assert.Equal(t, []string{"alice"}, ev.Groups[0].ApprovedBy)
+
+	ev = evaluateGroups(groups, []string{"alice", "bob"}, nil, nil)
+	assert.Equal(t, []string{"bob"}, ev.Groups[0].ApprovedBy)
+}
+
+// TestEvaluateGroupsExcluded tests approvals discarded from the quorum.
+func TestEvaluateGroupsExcluded(t *testing.T) {
+	groups := []conf.ApproverGroup{
+		{Name: "backend", Approvals: []string{"alice", "bob", "carol"}, MinApprov: 2},
+		{Name: "security", Approvals: []string{"alice", "sec1"}, MinApprov: 1},
+	}
+	excluded := map[string]string{"alice": "author of the MR", "eve": "pushed commits to the MR"}
+
+	ev := evaluateGroups(groups, []string{"alice", "bob", "eve"}, nil, excluded)
+	assert.False(t, ev.Mergeable())
+	assert.Equal(t, []DiscardedApproval{{Username: "alice", Reason: "author of the MR"}}, ev.Discarded)
+	assert.Equal(t, "Group 'backend' needs 1 more approval(s) (2 required from [alice bob carol]); "+
+		"Group 'security' needs 1 more approval(s) (1 required from [alice sec1]); "+
+		"Approval from 'alice' discarded: author of the MR", ev.Message())
+
+	ev = evaluateGroups(groups, []string{"alice", "bob", "carol", "sec1"}, nil, excluded)
+	assert.True(t, ev.Mergeable())
+	assert.Empty(t, ev.Message())
+}
+
+// TestConditionFailures tests the draft, conflicts and discussions conditions.
+func TestConditionFailures(t *testing.T) {
+	mr := gitlab.MergeRequest{Draft: true, HasConflicts: true, BlockingDiscussionsResolved: false}
+
+	assert.Empty(t, conditionFailures(conf.BranchRule{}, mr))
+
+	rule := conf.BranchRule{BlockDraft: true, BlockConflicts: true, BlockDiscussions: true}
+	assert.Equal(t, []string{
+		"MR is a draft",
+		"MR has conflicts with the target branch",
+		"MR has unresolved blocking discussions",
+	}, conditionFailures(rule, mr))
+
+	mr = gitlab.MergeRequest{BlockingDiscussionsResolved: true}
+	assert.Empty(t, conditionFailures(rule, mr))
+}
+
+// TestPipelineFailures tests the head pipeline condition.
+func TestPipelineFailures(t *testing.T) {
+	mr := gitlab.MergeRequest{Sha: "abc"}
+	assert.Equal(t, []string{"No pipeline found for the MR head commit"}, pipelineFailures(mr))
+
+	mr.HeadPipeline = &gitlab.Pipeline{ID: 10, Sha: "old", Status: "success"}
+	assert.Equal(t, []string{"No pipeline found for the MR head commit"}, pipelineFailures(mr))
+
+	mr.HeadPipeline = &gitlab.Pipeline{ID: 11, Sha: "abc", Status: "running"}
+	assert.Equal(t, []string{"Head pipeline #11 is running"}, pipelineFailures(mr))
+
+	mr.HeadPipeline.Status = "success"
+	assert.Empty(t, pipelineFailures(mr))
+}
+
+// TestApplyLabels tests blocking and bypass labels.
+func TestApplyLabels(t *testing.T) {
+	rules := []conf.LabelRule{
+		{Label: "do-not-merge", Block: true},
+		{Label: "hotfix", MinApprov: 1, Approvals: []string{"oncall1", "oncall2"}},
+	}
+	groups := []conf.ApproverGroup{
+		{Name: "backend", Approvals: []string{"alice", "bob"}, MinApprov: 2},
+	}
+
+	t.Run("No label", func(t *testing.T) {
+		updated, failures, bypasses := applyLabels(rules, nil, groups)
+		assert.Equal(t, groups, updated)
+		assert.Empty(t, failures)
+		assert.Empty(t, bypasses)
+	})
+
+	t.Run("Blocking label", func(t *testing.T) {
+		_, failures, bypasses := applyLabels(rules, []string{"do-not-merge"}, groups)
+		assert.Equal(t, []string{"Label 'do-not-merge' blocks the merge"}, failures)
+		assert.Empty(t, bypasses)
+	})
+
+	t.Run("Bypass label", func(t *testing.T) {
+		updated, failures, bypasses := applyLabels(rules, []string{"hotfix", "other"}, groups)
+		assert.Empty(t, failures)
+		assert.Len(t, bypasses, 1)
+		assert.Equal(t, []conf.ApproverGroup{
+			{Name: "backend", Approvals: []string{"alice", "bob"}, MinApprov: 1},
+			{Name: "label hotfix", Approvals: []string{"oncall1", "oncall2"}, MinApprov: 1},
+		}, updated)
+		// the original groups are not modified
+		assert.Equal(t, 2, groups[0].MinApprov)
+	})
+}
+
+// TestLabelNames tests reading labels from the MR API and from webhooks.
+func TestLabelNames(t *testing.T) {
+	labels := []interface{}{"hotfix", map[string]interface{}{"title": "bug"}, 12}
+	assert.Equal(t, []string{"hotfix", "bug"}, labelNames(labels))
+}
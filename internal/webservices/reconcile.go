@@ -0,0 +1,145 @@
+//
+// reconcile.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+// reconcileMr evaluates the MR and applies the evaluation only if the state
+// in gitlab drifted from it, e.g. after a lost webhook or a manual change.
+// It goes through the coalescer, like the webhook evaluations of the MR: if
+// one is pending, it is only asked to run once more and no drift is
+// reported.
+func (s *Service) reconcileMr(ctx context.Context, p conf.ApprovRule, mr_id int) (bool, error) {
+	drifted := false
+	err := s.coalescer.run(ctx, p.ProjectId, mr_id, false, 0, func(ctx context.Context, opened bool) error {
+		if opened {
+			return s.reinforceNewMr(ctx, p, mr_id)
+		}
+		d, err := s.correctDrift(ctx, p, mr_id)
+		drifted = drifted || d
+		return err
+	})
+	return drifted, err
+}
+
+// correctDrift applies the evaluation of the MR if the state in gitlab
+// drifted from it, reporting if it did.
+func (s *Service) correctDrift(ctx context.Context, p conf.ApprovRule, mr_id int) (bool, error) {
+	mr, ev, err := s.evaluateMrRule(ctx, p, mr_id)
+	if err != nil {
+		return false, err
+	}
+	mr.ProjectID = p.ProjectId
+	drifted, err := s.enforcer(p).Drifted(ctx, mr, ev)
+	if err != nil {
+		log.Err(err).Int("project_id", p.ProjectId).Int("mr", mr_id).Msg("failed checking drift, enforcing anyway")
+		drifted = true
+	}
+	if !drifted {
+		return false, nil
+	}
+	return true, s.applyEvaluation(ctx, p, mr, ev)
+}
+
+// Reconcile re-evaluates every opened MR of every project, with at most
+// reconcile_workers MRs at a time, and corrects the drifts. It stops early
+// when ctx is done.
+func (s *Service) Reconcile(ctx context.Context) {
+	type job struct {
+		p     conf.ApprovRule
+		mr_id int
+	}
+	workers := s.Config.ReconcileWorkers
+	if workers == 0 {
+		workers = conf.DefaultReconcileWorkers
+	}
+	start := time.Now()
+	var checked, drifts atomic.Int64
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				drifted, err := s.reconcileMr(ctx, j.p, j.mr_id)
+				if err != nil {
+					recordError(err)
+					log.Err(err).Int("project_id", j.p.ProjectId).Int("mr", j.mr_id).Msg("failed reconciling MR")
+					continue
+				}
+				checked.Add(1)
+				if drifted {
+					drifts.Add(1)
+					log.Info().Int("project_id", j.p.ProjectId).Int("mr", j.mr_id).Msg("MR drift corrected")
+				}
+			}
+		}()
+	}
+feed:
+	for _, p := range s.Config.Projects {
+		if ctx.Err() != nil {
+			break
+		}
+		mrList, err := s.openedMRs(ctx, p.ProjectId)
+		if err != nil {
+			recordError(err)
+			log.Err(err).Int("project_id", p.ProjectId).Msg("failed listing opened MRs")
+			continue
+		}
+		for _, mr := range mrList {
+			select {
+			case jobs <- job{p: p, mr_id: mr.Iid}:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	reconcileCounts.Add("passes", 1)
+	reconcileCounts.Add("checked", checked.Load())
+	reconcileCounts.Add("drifts", drifts.Load())
+	log.Info().Int64("checked", checked.Load()).Int64("drifts", drifts.Load()).Dur("took", time.Since(start)).Msg("reconciliation done")
+}
+
+// RunReconciler calls Reconcile at once, then every reconcile_interval,
+// plus a random delay up to reconcile_jitter so several instances do not hit
+// gitlab at the same time. It returns when ctx is done, after the running pass stops.
+func (s *Service) RunReconciler(ctx context.Context) {
+	interval := time.Duration(s.Config.ReconcileInterval) * time.Second
+	if interval == 0 {
+		interval = conf.DefaultReconcileInterval * time.Second
+	}
+	jitter := conf.DefaultReconcileJitter * time.Second
+	if s.Config.ReconcileJitter != nil {
+		jitter = time.Duration(*s.Config.ReconcileJitter) * time.Second
+	}
+	// the first pass, at startup, catches up with the events missed while
+	// stopped
+	s.Reconcile(ctx)
+	for {
+		timer := time.NewTimer(interval + time.Duration(rand.Int63n(int64(jitter)+1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.Reconcile(ctx)
+		}
+	}
+}
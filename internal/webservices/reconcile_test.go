@@ -0,0 +1,98 @@
+//
+// reconcile_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDbEnforcerDrifted tests the drift detection of the db enforcer.
+func TestDbEnforcerDrifted(t *testing.T) {
+	e := dbEnforcer{s: &Service{}}
+	ev := Evaluation{Failures: []string{"MR is a draft"}}
+	drifted, _ := e.Drifted(context.Background(), gitlab.MergeRequest{MergeStatus: statusCannotBeMerged, MergeError: "MR is a draft"}, ev)
+	assert.False(t, drifted)
+	drifted, _ = e.Drifted(context.Background(), gitlab.MergeRequest{MergeStatus: statusCanBeMerged}, ev)
+	assert.True(t, drifted)
+	drifted, _ = e.Drifted(context.Background(), gitlab.MergeRequest{MergeStatus: statusCanBeMerged}, Evaluation{})
+	assert.False(t, drifted)
+}
+
+// TestReconcile tests that only the MRs whose commit status drifted are
+// enforced again.
+func TestReconcile(t *testing.T) {
+	var posted atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"iid": 7}, {"iid": 8}]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"iid": 7, "project_id": 3, "sha": "sha7", "target_branch": "main"}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"iid": 8, "project_id": 3, "sha": "sha8", "target_branch": "main"}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7/approvals", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"approved_by": [{"user": {"username": "alice"}}]}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/8/approvals", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"approved_by": []}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/repository/commits/sha7/statuses", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "mergesentinel/approvals", "status": "success", "description": "Merge rules satisfied"}]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/repository/commits/sha8/statuses", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/3/statuses/{sha}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "sha8", r.PathValue("sha"))
+		posted.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{
+		Config: conf.Config{
+			GitlabURL:   srv.URL,
+			GitlabToken: "glpat-token",
+			Enforcer:    conf.EnforcerCommitStatus,
+			Projects:    []conf.ApprovRule{{ProjectId: 3, Approvals: []string{"alice"}, MinApprov: 1}},
+		},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+
+	checked, drifts := counter(reconcileCounts, "checked"), counter(reconcileCounts, "drifts")
+	s.Reconcile(context.Background())
+	assert.Equal(t, int64(1), posted.Load())
+	assert.Equal(t, checked+2, counter(reconcileCounts, "checked"))
+	assert.Equal(t, drifts+1, counter(reconcileCounts, "drifts"))
+
+	// a cancelled pass does not reconcile anything
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Reconcile(ctx)
+	assert.Equal(t, checked+2, counter(reconcileCounts, "checked"))
+}
+
+// counter returns the value of the key of the metrics map, 0 if not set.
+func counter(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -0,0 +1,251 @@
+//
+// statuscheck.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+// statusCheckName is the name of the external status check registered in
+// gitlab projects.
+const statusCheckName = "MergeSentinel"
+
+// statusCheckIDs keeps the id of the MergeSentinel external status check
+// of every project, and why registering them failed, if it did.
+type statusCheckIDs struct {
+	mu      sync.Mutex
+	ids     map[int]int
+	failure string
+}
+
+// setFailure records the result of RegisterStatusChecks, empty if it
+// succeeded.
+func (c *statusCheckIDs) setFailure(failure string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failure = failure
+}
+
+func (c *statusCheckIDs) getFailure() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failure
+}
+
+func (c *statusCheckIDs) get(project_id int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[project_id]
+	return id, ok
+}
+
+func (c *statusCheckIDs) set(project_id int, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ids == nil {
+		c.ids = map[int]int{}
+	}
+	c.ids[project_id] = id
+}
+
+// usesStatusCheckEnforcer reports if the external_status_check enforcer is
+// used by any project.
+func (s *Service) usesStatusCheckEnforcer() bool {
+	for _, p := range s.Config.Projects {
+		if s.Config.ProjectEnforcer(p) == conf.EnforcerStatusCheck {
+			return true
+		}
+	}
+	return false
+}
+
+// findStatusCheck returns the id of the project external status check
+// calling status_check_url, 0 if there is none.
+func (s *Service) findStatusCheck(ctx context.Context, project_id int) (int, error) {
+	checks, err := s.gitlab.ExternalStatusChecks(ctx, project_id)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range checks {
+		if c.ExternalURL == s.Config.StatusCheck {
+			return c.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// statusCheckID returns the id of the project external status check.
+func (s *Service) statusCheckID(ctx context.Context, project_id int) (int, error) {
+	if id, ok := s.statusChecks.get(project_id); ok {
+		return id, nil
+	}
+	id, err := s.findStatusCheck(ctx, project_id)
+	if err != nil {
+		return 0, err
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("no external status check calling '%s' in project %d", s.Config.StatusCheck, project_id)
+	}
+	s.statusChecks.set(project_id, id)
+	return id, nil
+}
+
+// RegisterStatusChecks creates the MergeSentinel external status check in
+// every project using the external_status_check enforcer, unless it exists.
+// A failure is reported by /readyz until a registration succeeds, see
+// RunStatusCheckRegistration.
+func (s *Service) RegisterStatusChecks(ctx context.Context) error {
+	err := s.registerStatusChecks(ctx)
+	if err != nil {
+		s.statusChecks.setFailure(err.Error())
+	} else {
+		s.statusChecks.setFailure("")
+	}
+	return err
+}
+
+// The delays between two registrations of the external status checks,
+// shorter while the last one failed.
+const (
+	statusCheckRegisterInterval = time.Hour
+	statusCheckRetryInterval    = 30 * time.Second
+)
+
+// RunStatusCheckRegistration registers the external status checks at once,
+// then again every 30 seconds while it fails, e.g. gitlab unreachable, then
+// every hour, e.g. if a check was deleted. It returns when ctx is done.
+func (s *Service) RunStatusCheckRegistration(ctx context.Context) {
+	if !s.usesStatusCheckEnforcer() {
+		return
+	}
+	for {
+		if err := s.RegisterStatusChecks(ctx); err != nil {
+			log.Err(err).Msg("failed registering external status checks")
+		}
+		interval := statusCheckRegisterInterval
+		if s.statusChecks.getFailure() != "" {
+			interval = statusCheckRetryInterval
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Service) registerStatusChecks(ctx context.Context) error {
+	for _, p := range s.Config.Projects {
+		if s.Config.ProjectEnforcer(p) != conf.EnforcerStatusCheck {
+			continue
+		}
+		id, err := s.findStatusCheck(ctx, p.ProjectId)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			check, err := s.gitlab.CreateExternalStatusCheck(ctx, p.ProjectId, statusCheckName, s.Config.StatusCheck, s.Config.StatusCheckSecret)
+			if err != nil {
+				return err
+			}
+			id = check.ID
+			log.Info().Int("project_id", p.ProjectId).Int("status_check_id", id).Msg("external status check registered")
+		} else if err := s.gitlab.SetExternalStatusCheckSecret(ctx, p.ProjectId, id, s.Config.StatusCheckSecret); err != nil {
+			return err
+		}
+		s.statusChecks.set(p.ProjectId, id)
+	}
+	return nil
+}
+
+// checkStatusCheckRequest authenticates an external status check call:
+// signed with status_check_secret, as the X-Gitlab-Signature hex
+// HMAC-SHA256 of the body. Gitlab sends no token on these calls.
+func (s *Service) checkStatusCheckRequest(r *http.Request, body []byte) error {
+	if s.Config.StatusCheckSecret == "" {
+		return apperr.New(apperr.Config, "status_check_secret is not defined")
+	}
+	signature, err := hex.DecodeString(r.Header.Get("X-Gitlab-Signature"))
+	if err != nil || len(signature) == 0 {
+		return apperr.New(apperr.Policy, "missing or malformed status check signature")
+	}
+	mac := hmac.New(sha256.New, []byte(s.Config.StatusCheckSecret))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return apperr.New(apperr.Policy, "invalid status check signature")
+	}
+	return nil
+}
+
+// PostStatusCheck receives the gitlab external status check calls, sent
+// with the merge request event payload, and answers them through the
+// status check API after evaluating the MR. It replies 403 if the call is
+// not authenticated or is not for the MergeSentinel status check.
+func (s *Service) PostStatusCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Err(err).Send()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var callback GitlabMREventWebhookCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		log.Err(err).Send()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cb_project := callback.ObjectAttributes.TargetProjectID
+	cb_mr_id := callback.ObjectAttributes.Iid
+	log.Debug().Int("project", cb_project).Int("mr_id", cb_mr_id).Msg("Status check received")
+	for _, p := range s.Config.Projects {
+		if p.ProjectId == cb_project && s.Config.ProjectEnforcer(p) == conf.EnforcerStatusCheck {
+			if err := s.checkStatusCheckRequest(r, body); err != nil {
+				replyError(w, err)
+				return
+			}
+			id, err := s.statusCheckID(r.Context(), p.ProjectId)
+			if err != nil {
+				replyError(w, err)
+				return
+			}
+			if cb_id := callback.ExternalApprovalRule.ID; cb_id != 0 && cb_id != id {
+				replyError(w, apperr.New(apperr.Policy, fmt.Sprintf("status check %d is not the MergeSentinel one (%d)", cb_id, id)))
+				return
+			}
+			if err := s.serializeMr(r.Context(), p, cb_mr_id); err != nil {
+				replyError(w, err)
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write([]byte("{ \"msg\": \"Status check received\" }\n"))
+	if err != nil {
+		log.Err(err).Send()
+	}
+}
@@ -0,0 +1,209 @@
+//
+// statuscheck_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStatusCheckEnforcer tests the registration of the external status
+// check and the responses posted for an evaluation.
+func TestStatusCheckEnforcer(t *testing.T) {
+	var created map[string]string
+	var response map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/external_status_checks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 1, "name": "other", "external_url": "https://other.example.com"}]`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/3/external_status_checks", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 42, "name": "MergeSentinel"}`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/3/merge_requests/7/status_check_responses", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&response))
+		w.WriteHeader(http.StatusCreated)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p := conf.ApprovRule{ProjectId: 3, Enforcer: conf.EnforcerStatusCheck}
+	s := &Service{
+		Config: conf.Config{
+			GitlabURL:         srv.URL,
+			GitlabToken:       "glpat-token",
+			StatusCheck:       "https://sentinel.example.com/api/v1/status_check",
+			StatusCheckSecret: "s3cr3t-s3cr3t-s3cr3t",
+			Projects:          []conf.ApprovRule{p},
+		},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+	assert.NoError(t, s.RegisterStatusChecks(context.Background()))
+	assert.Equal(t, map[string]string{"name": statusCheckName, "external_url": "https://sentinel.example.com/api/v1/status_check", "shared_secret": "s3cr3t-s3cr3t-s3cr3t"}, created)
+	id, ok := s.statusChecks.get(3)
+	assert.True(t, ok)
+	assert.Equal(t, 42, id)
+
+	assert.NoError(t, s.enforce(context.Background(), p, gitlab.MergeRequest{Iid: 7, Sha: "abc"}, Evaluation{Failures: []string{"blocked"}}))
+	assert.Equal(t, map[string]interface{}{"sha": "abc", "external_status_check_id": float64(42), "status": "failed"}, response)
+
+	assert.NoError(t, s.enforce(context.Background(), p, gitlab.MergeRequest{Iid: 7, Sha: "abc"}, Evaluation{}))
+	assert.Equal(t, "passed", response["status"])
+}
+
+// TestRegisterStatusChecksRetry tests that a registration failure is
+// reported until a later registration succeeds.
+func TestRegisterStatusChecksRetry(t *testing.T) {
+	up := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/external_status_checks", func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`[{"id": 42, "name": "MergeSentinel", "external_url": "https://sentinel.example.com/api/v1/status_check"}]`))
+	})
+	mux.HandleFunc("PUT /api/v4/projects/3/external_status_checks/42", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 42}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{
+		Config: conf.Config{
+			Enforcer:          conf.EnforcerStatusCheck,
+			StatusCheck:       "https://sentinel.example.com/api/v1/status_check",
+			StatusCheckSecret: "s3cr3t-s3cr3t-s3cr3t",
+			Projects:          []conf.ApprovRule{{ProjectId: 3}},
+		},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+	s.gitlab.Retries = 0
+
+	assert.Error(t, s.RegisterStatusChecks(context.Background()))
+	assert.NotEmpty(t, s.statusChecks.getFailure())
+	_, ok := s.statusChecks.get(3)
+	assert.False(t, ok)
+
+	up = true
+	assert.NoError(t, s.RegisterStatusChecks(context.Background()))
+	assert.Empty(t, s.statusChecks.getFailure())
+	id, _ := s.statusChecks.get(3)
+	assert.Equal(t, 42, id)
+}
+
+// TestPostStatusCheckFailure tests that a gitlab failure is replied with 502
+// and counted, instead of stopping the service.
+func TestPostStatusCheckFailure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"iid": 7, "sha": `))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{
+		Config: conf.Config{
+			Enforcer:          conf.EnforcerStatusCheck,
+			StatusCheckSecret: "s3cr3t-s3cr3t-s3cr3t",
+			Projects:          []conf.ApprovRule{{ProjectId: 3}},
+		},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+	s.statusChecks.set(3, 5)
+	before := counter(errorCounts, "upstream")
+	body := `{"object_kind": "merge_request", "object_attributes": {"iid": 7, "target_project_id": 3}, "external_approval_rule": {"id": 5}}`
+	mac := hmac.New(sha256.New, []byte(s.Config.StatusCheckSecret))
+	mac.Write([]byte(body))
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/status_check", strings.NewReader(body))
+	r.Header.Set("X-Gitlab-Signature", hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	s.PostStatusCheck(w, r)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, before+1, counter(errorCounts, "upstream"))
+}
+
+// TestPostStatusCheckAuth tests that the status check calls must be signed
+// with the shared secret, set on the existing check at registration, and
+// must be for the MergeSentinel status check.
+func TestPostStatusCheckAuth(t *testing.T) {
+	secret := "s3cr3t-s3cr3t-s3cr3t"
+	var updated map[string]string
+	evaluated := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/external_status_checks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 42, "name": "MergeSentinel", "external_url": "https://sentinel.example.com/api/v1/status_check"}]`))
+	})
+	mux.HandleFunc("PUT /api/v4/projects/3/external_status_checks/42", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&updated))
+		w.Write([]byte(`{"id": 42}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		evaluated++
+		w.WriteHeader(http.StatusNotFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{
+		Config: conf.Config{
+			Enforcer:          conf.EnforcerStatusCheck,
+			StatusCheck:       "https://sentinel.example.com/api/v1/status_check",
+			StatusCheckSecret: secret,
+			Projects:          []conf.ApprovRule{{ProjectId: 3}},
+		},
+		gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client()),
+	}
+	s.gitlab.Retries = 0
+	assert.NoError(t, s.RegisterStatusChecks(context.Background()))
+	assert.Equal(t, map[string]string{"shared_secret": secret}, updated)
+
+	post := func(body string, signature string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/status_check", strings.NewReader(body))
+		if signature != "" {
+			r.Header.Set("X-Gitlab-Signature", signature)
+		}
+		w := httptest.NewRecorder()
+		s.PostStatusCheck(w, r)
+		return w.Code
+	}
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	body := `{"object_kind": "merge_request", "object_attributes": {"iid": 7, "target_project_id": 3}, "external_approval_rule": {"id": 42}}`
+	assert.Equal(t, http.StatusForbidden, post(body, ""))
+	// the webhook token is not accepted instead of the signature
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/status_check", strings.NewReader(body))
+	r.Header.Set("X-Gitlab-Token", secret)
+	w := httptest.NewRecorder()
+	s.PostStatusCheck(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusForbidden, post(body, sign(body+" ")))
+	assert.Equal(t, 0, evaluated)
+
+	// a forged check id is refused and not kept
+	forged := `{"object_kind": "merge_request", "object_attributes": {"iid": 7, "target_project_id": 3}, "external_approval_rule": {"id": 5}}`
+	assert.Equal(t, http.StatusForbidden, post(forged, sign(forged)))
+	id, _ := s.statusChecks.get(3)
+	assert.Equal(t, 42, id)
+	assert.Equal(t, 0, evaluated)
+
+	assert.Equal(t, http.StatusBadGateway, post(body, sign(body)))
+	assert.Equal(t, 1, evaluated)
+}
@@ -0,0 +1,190 @@
+//
+// summary.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+)
+
+// summaryMarker identifies the MergeSentinel summary note of a MR.
+const summaryMarker = "<!-- mergesentinel:summary -->"
+
+// summaryNote is the summary note of a MR. mu serializes the updates of
+// the note, so it is never created twice.
+type summaryNote struct {
+	mu    sync.Mutex
+	found bool
+	id    int
+	body  string
+}
+
+// summaryNotes keeps the summary note of every MR already found or created.
+// mu only guards the map; the notes are updated under their own lock, so a
+// MR never waits for the gitlab calls of another.
+type summaryNotes struct {
+	mu    sync.Mutex
+	notes map[string]*summaryNote
+}
+
+// note returns the summary note of the MR key, created if unknown.
+func (n *summaryNotes) note(key string) *summaryNote {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.notes == nil {
+		n.notes = map[string]*summaryNote{}
+	}
+	note, ok := n.notes[key]
+	if !ok {
+		note = &summaryNote{}
+		n.notes[key] = note
+	}
+	return note
+}
+
+// forget drops the summary note of the MR key, e.g. once it is merged.
+func (n *summaryNotes) forget(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.notes, key)
+}
+
+// currentUser is the gitlab user of the token, looked up once.
+type currentUser struct {
+	mu   sync.Mutex
+	user *gitlab.User
+}
+
+// tokenUser returns the gitlab user of the token. A failed lookup is retried
+// on the next call.
+func (s *Service) tokenUser(ctx context.Context) (gitlab.User, error) {
+	s.self.mu.Lock()
+	defer s.self.mu.Unlock()
+	if s.self.user == nil {
+		user, err := s.gitlab.CurrentUser(ctx)
+		if err != nil {
+			return gitlab.User{}, err
+		}
+		s.self.user = &user
+	}
+	return *s.self.user, nil
+}
+
+// mentions formats usernames as gitlab mentions, keeping group references.
+func mentions(usernames []string) string {
+	if len(usernames) == 0 {
+		return "-"
+	}
+	m := make([]string, 0, len(usernames))
+	for _, u := range usernames {
+		if strings.HasPrefix(u, conf.GroupPrefix) {
+			m = append(m, "`"+u+"`")
+		} else {
+			m = append(m, "`@"+u+"`")
+		}
+	}
+	return strings.Join(m, ", ")
+}
+
+// summaryBody renders the summary note of the evaluation.
+func summaryBody(ev Evaluation) string {
+	var b strings.Builder
+	b.WriteString(summaryMarker + "\n")
+	b.WriteString("### MergeSentinel\n\n")
+	if ev.Mergeable() {
+		b.WriteString(":white_check_mark: This merge request can be merged.\n")
+	} else {
+		b.WriteString(":no_entry: This merge request cannot be merged yet.\n")
+	}
+	if len(ev.Groups) > 0 {
+		b.WriteString("\n| Group | Required | Approved by | Still needed |\n")
+		b.WriteString("|---|---|---|---|\n")
+		for _, g := range ev.Groups {
+			name := g.Name
+			if name == "" {
+				name = "approvers"
+			}
+			needed := ":white_check_mark:"
+			if missing := g.Missing(); missing > 0 {
+				needed = fmt.Sprintf("%d from %s", missing, mentions(g.Pending()))
+			}
+			fmt.Fprintf(&b, "| %s | %d | %s | %s |\n", name, g.Required, mentions(g.ApprovedBy), needed)
+		}
+	}
+	if len(ev.Failures) > 0 {
+		b.WriteString("\n**Failing conditions**\n\n")
+		for _, f := range ev.Failures {
+			fmt.Fprintf(&b, "- %s\n", f)
+		}
+	}
+	if len(ev.Discarded) > 0 {
+		b.WriteString("\n**Discarded approvals**\n\n")
+		for _, d := range ev.Discarded {
+			fmt.Fprintf(&b, "- `@%s`: %s\n", d.Username, d.Reason)
+		}
+	}
+	return b.String()
+}
+
+// findSummaryNote returns the MR note holding the summary marker, written
+// by the user of the token: a note copied by anybody else is not edited.
+func (s *Service) findSummaryNote(ctx context.Context, project_id int, mr_id int) (gitlab.Note, bool, error) {
+	user, err := s.tokenUser(ctx)
+	if err != nil {
+		return gitlab.Note{}, false, err
+	}
+	notes, err := s.gitlab.Notes(ctx, project_id, mr_id)
+	if err != nil {
+		return gitlab.Note{}, false, err
+	}
+	for _, n := range notes {
+		if !n.System && n.Author.ID == user.ID && strings.Contains(n.Body, summaryMarker) {
+			return n, true, nil
+		}
+	}
+	return gitlab.Note{}, false, nil
+}
+
+// updateSummaryNote creates or edits in place the summary note of the MR.
+func (s *Service) updateSummaryNote(ctx context.Context, project_id int, mr_id int, ev Evaluation) error {
+	body := summaryBody(ev)
+	cached := s.summaries.note(mrKey(project_id, mr_id))
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	if !cached.found {
+		note, found, err := s.findSummaryNote(ctx, project_id, mr_id)
+		if err != nil {
+			return err
+		}
+		if found {
+			cached.found, cached.id, cached.body = true, note.ID, note.Body
+		}
+	}
+	if cached.found && cached.body == body {
+		return nil
+	}
+	var note gitlab.Note
+	var err error
+	if cached.found {
+		note, err = s.gitlab.UpdateNote(ctx, project_id, mr_id, cached.id, body)
+	} else {
+		note, err = s.gitlab.CreateNote(ctx, project_id, mr_id, body)
+	}
+	if err != nil {
+		// the note may have been deleted, look for it again next time
+		cached.found = false
+		return err
+	}
+	cached.found, cached.id, cached.body = true, note.ID, body
+	return nil
+}
@@ -0,0 +1,148 @@
+//
+// summary_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSummaryBody tests the rendering of the summary note.
+func TestSummaryBody(t *testing.T) {
+	ev := Evaluation{
+		Groups: []GroupStatus{
+			{Required: 1, Approvers: []string{"alice"}, ApprovedBy: []string{"alice"}},
+			{Name: "security", Required: 1, Approvers: []string{"sec1", "@org/sec"}},
+		},
+		Failures:  []string{"MR is a draft"},
+		Discarded: []DiscardedApproval{{Username: "bob", Reason: "author of the MR"}},
+	}
+	assert.Equal(t, summaryMarker+`
+### MergeSentinel
+
+:no_entry: This merge request cannot be merged yet.
+
+| Group | Required | Approved by | Still needed |
+|---|---|---|---|
+| approvers | 1 | `+"`@alice`"+` | :white_check_mark: |
+| security | 1 | - | 1 from `+"`@sec1`, `@org/sec`"+` |
+
+**Failing conditions**
+
+- MR is a draft
+
+**Discarded approvals**
+
+- `+"`@bob`"+`: author of the MR
+`, summaryBody(ev))
+}
+
+// TestUpdateSummaryNote tests that the summary note is created once, then
+// edited in place, and that a copy of it by another user is not adopted.
+func TestUpdateSummaryNote(t *testing.T) {
+	var created, edited, users int
+	var body string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		users++
+		w.Write([]byte(`{"id": 5, "username": "sentinel"}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7/notes", func(w http.ResponseWriter, r *http.Request) {
+		notes := []gitlab.Note{{ID: 1, Body: "LGTM"}, {ID: 2, Body: summaryMarker + " copied"}}
+		notes[1].Author.ID = 9
+		if created > 0 {
+			notes = append(notes, gitlab.Note{ID: 55, Body: body})
+			notes[2].Author.ID = 5
+		}
+		json.NewEncoder(w).Encode(notes)
+	})
+	mux.HandleFunc("POST /api/v4/projects/3/merge_requests/7/notes", func(w http.ResponseWriter, r *http.Request) {
+		created++
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		body = payload["body"]
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 55}`))
+	})
+	mux.HandleFunc("PUT /api/v4/projects/3/merge_requests/7/notes/55", func(w http.ResponseWriter, r *http.Request) {
+		edited++
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		body = payload["body"]
+		w.Write([]byte(`{"id": 55}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := &Service{Config: conf.Config{GitlabURL: srv.URL, GitlabToken: "glpat-token"}, gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client())}
+
+	blocked := Evaluation{Failures: []string{"MR is a draft"}}
+	assert.NoError(t, s.updateSummaryNote(context.Background(), 3, 7, blocked))
+	assert.NoError(t, s.updateSummaryNote(context.Background(), 3, 7, blocked))
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 0, edited)
+
+	assert.NoError(t, s.updateSummaryNote(context.Background(), 3, 7, Evaluation{}))
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 1, edited)
+	assert.Contains(t, body, "can be merged")
+
+	// forgotten once merged, found again if evaluated anyway
+	s.summaries.forget(mrKey(3, 7))
+	assert.NoError(t, s.updateSummaryNote(context.Background(), 3, 7, Evaluation{}))
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 1, edited)
+	assert.Equal(t, 1, users)
+}
+
+// TestUpdateSummaryNoteConcurrent tests that a MR waiting for gitlab does
+// not block the summary note of another MR.
+func TestUpdateSummaryNoteConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 5, "username": "sentinel"}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/{iid}/notes", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("iid") == "7" {
+			<-release
+		}
+		w.Write([]byte(`[]`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/3/merge_requests/{iid}/notes", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 55}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer close(release)
+	s := &Service{gitlab: gitlab.New(srv.URL, "glpat-token", srv.Client())}
+
+	slow := make(chan error, 1)
+	go func() { slow <- s.updateSummaryNote(context.Background(), 3, 7, Evaluation{}) }()
+	done := make(chan error, 1)
+	go func() { done <- s.updateSummaryNote(context.Background(), 3, 8, Evaluation{}) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("summary note of MR 8 blocked by MR 7")
+	}
+	select {
+	case <-slow:
+		t.Fatal("summary note of MR 7 updated before gitlab replied")
+	default:
+	}
+}
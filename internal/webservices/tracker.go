@@ -0,0 +1,159 @@
+//
+// tracker.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// approvalTrackerFile is the file, in the state directory, used to persist
+// the approval tracker.
+const approvalTrackerFile = "approvals.json"
+
+// approvalTracker keeps, for every MR, the head sha seen when each user
+// approved it. It is used to discard approvals given before the latest push.
+type approvalTracker struct {
+	mu   sync.Mutex
+	path string
+	// MRs maps "<project_id>:<iid>" to username -> sha
+	MRs map[string]map[string]string `json:"merge_requests"`
+}
+
+func mrKey(project_id int, mr_id int) string {
+	return fmt.Sprintf("%d:%d", project_id, mr_id)
+}
+
+// newApprovalTracker creates a tracker persisted in dir. The tracker is
+// kept only in memory when dir is empty.
+func newApprovalTracker(dir string) (*approvalTracker, error) {
+	t := &approvalTracker{MRs: map[string]map[string]string{}}
+	if dir == "" {
+		return t, nil
+	}
+	t.path = filepath.Join(dir, approvalTrackerFile)
+	content, err := os.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed loading approval tracker")
+	}
+	if err := json.Unmarshal(content, t); err != nil {
+		return nil, errors.Wrap(err, "failed parsing approval tracker")
+	}
+	if t.MRs == nil {
+		t.MRs = map[string]map[string]string{}
+	}
+	return t, nil
+}
+
+// save writes the tracker to disk. It must be called with mu locked.
+func (t *approvalTracker) save() {
+	if t.path == "" {
+		return
+	}
+	content, err := json.Marshal(t)
+	if err != nil {
+		log.Err(err).Msg("failed encoding approval tracker")
+		return
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		log.Err(err).Msg("failed saving approval tracker")
+		return
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		log.Err(err).Msg("failed saving approval tracker")
+	}
+}
+
+// recordApproval stores the sha of the MR head when username approved it.
+func (t *approvalTracker) recordApproval(project_id int, mr_id int, username string, sha string) {
+	if t == nil || username == "" || sha == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := mrKey(project_id, mr_id)
+	if t.MRs[key] == nil {
+		t.MRs[key] = map[string]string{}
+	}
+	if t.MRs[key][username] == sha {
+		return
+	}
+	t.MRs[key][username] = sha
+	t.save()
+}
+
+// removeApproval forgets the approval of username.
+func (t *approvalTracker) removeApproval(project_id int, mr_id int, username string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := mrKey(project_id, mr_id)
+	if _, ok := t.MRs[key][username]; !ok {
+		return
+	}
+	delete(t.MRs[key], username)
+	if len(t.MRs[key]) == 0 {
+		delete(t.MRs, key)
+	}
+	t.save()
+}
+
+// forget removes every approval of the MR, e.g. when it is merged or closed.
+func (t *approvalTracker) forget(project_id int, mr_id int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := mrKey(project_id, mr_id)
+	if _, ok := t.MRs[key]; !ok {
+		return
+	}
+	delete(t.MRs, key)
+	t.save()
+}
+
+// approvalSha returns the head sha recorded when username approved the MR.
+func (t *approvalTracker) approvalSha(project_id int, mr_id int, username string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sha, ok := t.MRs[mrKey(project_id, mr_id)][username]
+	return sha, ok
+}
+
+// staleApprovals returns, by username, why the approvals of approvedBy are
+// not given on the MR head sha. An approval not tracked, e.g. given before
+// MergeSentinel saw the MR, is stale: the commit it was given on is unknown.
+func (t *approvalTracker) staleApprovals(project_id int, mr_id int, sha string, approvedBy []string) map[string]string {
+	stale := map[string]string{}
+	for _, by := range approvedBy {
+		given, ok := t.approvalSha(project_id, mr_id, by)
+		switch {
+		case !ok:
+			stale[by] = "given on an unknown commit, approve again"
+		case given != sha:
+			stale[by] = fmt.Sprintf("given on %.8s, before the latest push (%.8s)", given, sha)
+		}
+	}
+	return stale
+}
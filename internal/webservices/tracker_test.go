@@ -0,0 +1,61 @@
+//
+// tracker_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestApprovalTracker tests recording approvals and persisting them.
+func TestApprovalTracker(t *testing.T) {
+	dir := t.TempDir()
+	tracker, err := newApprovalTracker(dir)
+	assert.NoError(t, err)
+
+	tracker.recordApproval(1, 2, "alice", "sha1")
+	tracker.recordApproval(1, 2, "bob", "sha2")
+	tracker.recordApproval(1, 3, "alice", "sha3")
+	tracker.removeApproval(1, 2, "bob")
+	tracker.forget(1, 3)
+
+	// reload from disk
+	tracker, err = newApprovalTracker(dir)
+	assert.NoError(t, err)
+	sha, ok := tracker.approvalSha(1, 2, "alice")
+	assert.True(t, ok)
+	assert.Equal(t, "sha1", sha)
+	_, ok = tracker.approvalSha(1, 2, "bob")
+	assert.False(t, ok)
+	_, ok = tracker.approvalSha(1, 3, "alice")
+	assert.False(t, ok)
+
+	// in memory tracker
+	tracker, err = newApprovalTracker("")
+	assert.NoError(t, err)
+	tracker.recordApproval(1, 2, "alice", "sha1")
+	sha, _ = tracker.approvalSha(1, 2, "alice")
+	assert.Equal(t, "sha1", sha)
+}
+
+// TestStaleApprovals tests that, with reset_on_push, approvals given on an
+// older commit or not tracked are stale.
+func TestStaleApprovals(t *testing.T) {
+	tracker, err := newApprovalTracker("")
+	assert.NoError(t, err)
+	tracker.recordApproval(1, 2, "alice", "sha2")
+	tracker.recordApproval(1, 2, "bob", "sha1")
+	assert.Equal(t, map[string]string{
+		"bob":   "given on sha1, before the latest push (sha2)",
+		"carol": "given on an unknown commit, approve again",
+	}, tracker.staleApprovals(1, 2, "sha2", []string{"alice", "bob", "carol"}))
+	// an untracked approval is not adopted on the head commit
+	_, ok := tracker.approvalSha(1, 2, "carol")
+	assert.False(t, ok)
+}
@@ -7,99 +7,38 @@
 
 package webservices
 
-import (
-	"time"
-	"database/sql"
-)
-
-type GitlabMR struct {
-	// Based on gitlab version 17.2
-	ID             int         `json:"id"`
-	Iid            int         `json:"iid"`
-	ProjectID      int         `json:"project_id"`
-	Title          string      `json:"title"`
-	Description    string      `json:"description"`
-	State          string      `json:"state"`
-	CreatedAt      time.Time   `json:"created_at"`
-	UpdatedAt      time.Time   `json:"updated_at"`
-	MergedBy       interface{} `json:"merged_by"`
-	MergeUser      interface{} `json:"merge_user"`
-	MergedAt       interface{} `json:"merged_at"`
-	ClosedBy       interface{} `json:"closed_by"`
-	ClosedAt       interface{} `json:"closed_at"`
-	TargetBranch   string      `json:"target_branch"`
-	SourceBranch   string      `json:"source_branch"`
-	UserNotesCount int         `json:"user_notes_count"`
-	Upvotes        int         `json:"upvotes"`
-	Downvotes      int         `json:"downvotes"`
-	Author         struct {
-		ID        int    `json:"id"`
-		Username  string `json:"username"`
-		Name      string `json:"name"`
-		State     string `json:"state"`
-		Locked    bool   `json:"locked"`
-		AvatarURL string `json:"avatar_url"`
-		WebURL    string `json:"web_url"`
-	} `json:"author"`
-	Assignees                 []interface{} `json:"assignees"`
-	Assignee                  interface{}   `json:"assignee"`
-	Reviewers                 []interface{} `json:"reviewers"`
-	SourceProjectID           int           `json:"source_project_id"`
-	TargetProjectID           int           `json:"target_project_id"`
-	Labels                    []interface{} `json:"labels"`
-	Draft                     bool          `json:"draft"`
-	Imported                  bool          `json:"imported"`
-	ImportedFrom              string        `json:"imported_from"`
-	WorkInProgress            bool          `json:"work_in_progress"`
-	Milestone                 interface{}   `json:"milestone"`
-	MergeWhenPipelineSucceeds bool          `json:"merge_when_pipeline_succeeds"`
-	MergeStatus               string        `json:"merge_status"`
-	DetailedMergeStatus       string        `json:"detailed_merge_status"`
-	Sha                       string        `json:"sha"`
-	MergeCommitSha            interface{}   `json:"merge_commit_sha"`
-	SquashCommitSha           interface{}   `json:"squash_commit_sha"`
-	DiscussionLocked          interface{}   `json:"discussion_locked"`
-	ShouldRemoveSourceBranch  interface{}   `json:"should_remove_source_branch"`
-	ForceRemoveSourceBranch   bool          `json:"force_remove_source_branch"`
-	PreparedAt                time.Time     `json:"prepared_at"`
-	Reference                 string        `json:"reference"`
-	References                struct {
-		Short    string `json:"short"`
-		Relative string `json:"relative"`
-		Full     string `json:"full"`
-	} `json:"references"`
-	WebURL    string `json:"web_url"`
-	TimeStats struct {
-		TimeEstimate        int         `json:"time_estimate"`
-		TotalTimeSpent      int         `json:"total_time_spent"`
-		HumanTimeEstimate   interface{} `json:"human_time_estimate"`
-		HumanTotalTimeSpent interface{} `json:"human_total_time_spent"`
-	} `json:"time_stats"`
-	Squash               bool `json:"squash"`
-	SquashOnMerge        bool `json:"squash_on_merge"`
-	TaskCompletionStatus struct {
-		Count          int `json:"count"`
-		CompletedCount int `json:"completed_count"`
-	} `json:"task_completion_status"`
-	HasConflicts                bool `json:"has_conflicts"`
-	BlockingDiscussionsResolved bool `json:"blocking_discussions_resolved"`
-}
-type GitlabApproval struct {
+type GitlabPipelineEventWebhookCallback struct {
 	// Based on gitlab version 17.2
-	UserHasApproved bool `json:"user_has_approved"`
-	UserCanApprove  bool `json:"user_can_approve"`
-	Approved        bool `json:"approved"`
-	ApprovedBy      []struct {
-		User struct {
-			ID        int    `json:"id"`
-			Username  string `json:"username"`
-			Name      string `json:"name"`
-			State     string `json:"state"`
-			Locked    bool   `json:"locked"`
-			AvatarURL string `json:"avatar_url"`
-			WebURL    string `json:"web_url"`
-		} `json:"user"`
-	} `json:"approved_by"`
+	ObjectKind       string `json:"object_kind"`
+	ObjectAttributes struct {
+		ID             int    `json:"id"`
+		Iid            int    `json:"iid"`
+		Ref            string `json:"ref"`
+		Tag            bool   `json:"tag"`
+		Sha            string `json:"sha"`
+		Status         string `json:"status"`
+		DetailedStatus string `json:"detailed_status"`
+		Source         string `json:"source"`
+	} `json:"object_attributes"`
+	MergeRequest *struct {
+		ID              int    `json:"id"`
+		Iid             int    `json:"iid"`
+		SourceBranch    string `json:"source_branch"`
+		SourceProjectID int    `json:"source_project_id"`
+		TargetBranch    string `json:"target_branch"`
+		TargetProjectID int    `json:"target_project_id"`
+		State           string `json:"state"`
+	} `json:"merge_request"`
+	User struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		ID                int    `json:"id"`
+		Name              string `json:"name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
 }
 
 type GitlabMREventWebhookCallback struct {
@@ -110,7 +49,13 @@ type GitlabMREventWebhookCallback struct {
 			Previous string `json:"previous"`
 		} `json:"updated_at"`
 	} `json:"changes"`
-	EventType        string        `json:"event_type"`
+	EventType string `json:"event_type"`
+	// only sent to external status checks
+	ExternalApprovalRule struct {
+		ID          int    `json:"id"`
+		Name        string `json:"name"`
+		ExternalURL string `json:"external_url"`
+	} `json:"external_approval_rule"`
 	Labels           []interface{} `json:"labels"`
 	ObjectAttributes struct {
 		Action                      string        `json:"action"`
@@ -152,6 +97,7 @@ type GitlabMREventWebhookCallback struct {
 		MergeUserID               interface{}   `json:"merge_user_id"`
 		MergeWhenPipelineSucceeds bool          `json:"merge_when_pipeline_succeeds"`
 		MilestoneID               interface{}   `json:"milestone_id"`
+		OldRev                    string        `json:"oldrev"`
 		PreparedAt                string        `json:"prepared_at"`
 		ReviewerIds               []interface{} `json:"reviewer_ids"`
 		Source                    struct {
@@ -238,12 +184,3 @@ type GitlabMREventWebhookCallback struct {
 		Username  string `json:"username"`
 	} `json:"user"`
 }
-
-type MergeRequestData struct {
-	Id int `db:"id"`
-	TargetProjectId int `db:"target_project_id"`
-	Iid int `db:"iid"`
-	Description sql.NullString `db:"description"`
-	MergeStatus string `db:"merge_status"` 
-	MergeError sql.NullString `db:"merge_error"`
-}
@@ -0,0 +1,272 @@
+//
+// webhooks.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/apperr"
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/cropalato/MergeSentinel/internal/queue"
+	"github.com/cropalato/MergeSentinel/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// webhookQueueFile is the file, in the state directory, used to persist the
+// webhook events not processed yet.
+const webhookQueueFile = "webhooks.log"
+
+const (
+	// minWebhookBackoff and maxWebhookBackoff bound the delay before a failed
+	// event is processed again.
+	minWebhookBackoff = 5 * time.Second
+	maxWebhookBackoff = 10 * time.Minute
+)
+
+// Kinds of webhook events.
+const (
+	eventMergeRequest = "merge_request"
+	eventPipeline     = "pipeline"
+	// eventRejected blocks the MR of an event refused by PostApproval.
+	eventRejected = "rejected"
+)
+
+// webhookEvent is a webhook event waiting in the queue.
+type webhookEvent struct {
+	Kind     string          `json:"kind"`
+	Received time.Time       `json:"received"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	// ProjectID, Iid and Reason describe the MR of a rejected event.
+	ProjectID int    `json:"project_id,omitempty"`
+	Iid       int    `json:"iid,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// openWebhookQueue opens the webhook queue persisted in dir, or in
+// conf.DefaultQueueDir when dir is empty.
+func openWebhookQueue(dir string) (*queue.Queue, error) {
+	if dir == "" {
+		dir = conf.DefaultQueueDir
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed creating webhook queue directory: %w", err)
+		}
+		log.Warn().Str("dir", dir).Msg("state_dir not defined, webhook queue persisted in default directory")
+	}
+	return queue.Open(filepath.Join(dir, webhookQueueFile))
+}
+
+// queueEvent adds the event to the webhook queue.
+func (s *Service) queueEvent(ev webhookEvent) error {
+	ev.Received = time.Now()
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err = s.queue.Push(payload); err != nil {
+		return err
+	}
+	webhookEvents.Add("queued", 1)
+	return nil
+}
+
+// processEvent evaluates again the MRs concerned by the event.
+func (s *Service) processEvent(ctx context.Context, ev webhookEvent) error {
+	switch ev.Kind {
+	case eventMergeRequest:
+		var callback GitlabMREventWebhookCallback
+		if err := json.Unmarshal(ev.Body, &callback); err != nil {
+			return err
+		}
+		return s.processMergeRequest(ctx, callback)
+	case eventPipeline:
+		var callback GitlabPipelineEventWebhookCallback
+		if err := json.Unmarshal(ev.Body, &callback); err != nil {
+			return err
+		}
+		return s.processPipeline(ctx, callback)
+	case eventRejected:
+		var errs []error
+		for _, p := range s.Config.Projects {
+			if p.ProjectId == ev.ProjectID {
+				errs = append(errs, s.enforce(ctx, p, gitlab.MergeRequest{ProjectID: ev.ProjectID, Iid: ev.Iid}, Evaluation{Failures: []string{ev.Reason}}))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	log.Warn().Str("kind", ev.Kind).Msg("unknown webhook event")
+	return nil
+}
+
+// processMergeRequest tracks the approvals of the merge request event and
+// evaluates again its MR, merging the evaluations of a burst of events. The
+// summary note of a merged or closed MR is no longer kept.
+func (s *Service) processMergeRequest(ctx context.Context, callback GitlabMREventWebhookCallback) error {
+	cb_action := callback.ObjectAttributes.Action
+	cb_mr_id := callback.ObjectAttributes.Iid
+	var errs []error
+	for _, p := range s.Config.Projects {
+		if p.ProjectId != callback.ObjectAttributes.TargetProjectID {
+			continue
+		}
+		s.trackApproval(callback)
+		if cb_action == "merge" || cb_action == "close" {
+			s.summaries.forget(mrKey(p.ProjectId, cb_mr_id))
+		}
+		if evaluatesMR(callback) {
+			opened := cb_action == "open" || cb_action == "reopen"
+			errs = append(errs, s.coalesceMr(ctx, p, cb_mr_id, opened))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// processPipeline evaluates again the MR of a merge request pipeline or the
+// opened MRs whose source branch is the pipeline ref.
+func (s *Service) processPipeline(ctx context.Context, callback GitlabPipelineEventWebhookCallback) error {
+	cb_project := callback.Project.ID
+	if callback.MergeRequest != nil {
+		cb_project = callback.MergeRequest.TargetProjectID
+	}
+	var errs []error
+	for _, p := range s.Config.Projects {
+		if p.ProjectId != cb_project {
+			continue
+		}
+		if callback.MergeRequest != nil {
+			errs = append(errs, s.coalesceMr(ctx, p, callback.MergeRequest.Iid, false))
+			continue
+		}
+		if callback.ObjectAttributes.Tag {
+			continue
+		}
+		mrList, err := s.gitlab.MergeRequests(ctx, p.ProjectId, url.Values{"state": {"opened"}, "source_branch": {callback.ObjectAttributes.Ref}})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, mr := range mrList {
+			if mr.Sha == callback.ObjectAttributes.Sha {
+				errs = append(errs, s.coalesceMr(ctx, p, mr.Iid, false))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retryable reports if an event failing with err can succeed later. Invalid
+// events or config, policy violations, gitlab refusing the token or MRs not
+// found never do.
+func retryable(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, apperr.ErrConfig), errors.Is(err, apperr.ErrPolicy):
+		return false
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return false
+	case errors.Is(err, gitlab.ErrUnauthorized), errors.Is(err, gitlab.ErrForbidden), errors.Is(err, gitlab.ErrNotFound):
+		return false
+	case errors.Is(err, store.ErrNotFound):
+		return false
+	}
+	return true
+}
+
+// webhookBackoff returns the delay before the retry number attempt (0
+// based) of a failed event.
+func webhookBackoff(attempt int) time.Duration {
+	d := minWebhookBackoff << attempt
+	if d <= 0 || d > maxWebhookBackoff {
+		d = maxWebhookBackoff
+	}
+	return d
+}
+
+// handleEvent processes the queued event and removes it from the queue, or
+// puts it back to be retried later if it failed.
+func (s *Service) handleEvent(ctx context.Context, item queue.Item) {
+	retries := s.Config.WebhookRetries
+	if retries == 0 {
+		retries = conf.DefaultWebhookRetries
+	}
+	var ev webhookEvent
+	err := json.Unmarshal(item.Payload, &ev)
+	if err == nil {
+		log.Debug().Str("kind", ev.Kind).Uint64("event", item.ID).Int("attempt", item.Attempts+1).Msg("processing webhook event")
+		err = s.processEvent(ctx, ev)
+	}
+	switch {
+	case err == nil:
+		webhookEvents.Add("processed", 1)
+	case ctx.Err() != nil:
+		// shutting down, the event is processed again after the restart
+		return
+	case !retryable(err) || item.Attempts >= retries:
+		recordError(err)
+		webhookEvents.Add("dropped", 1)
+		log.Err(err).Str("kind", ev.Kind).Str("error_kind", apperr.KindOf(err).String()).Uint64("event", item.ID).Int("attempts", item.Attempts+1).Msg("dropping webhook event")
+	default:
+		recordError(err)
+		webhookEvents.Add("retried", 1)
+		delay := webhookBackoff(item.Attempts)
+		log.Warn().Err(err).Str("kind", ev.Kind).Uint64("event", item.ID).Dur("delay", delay).Msg("webhook event failed, retrying later")
+		if err := s.queue.Retry(item.ID, delay); err != nil {
+			log.Err(err).Uint64("event", item.ID).Msg("failed requeuing webhook event")
+		}
+		return
+	}
+	if err := s.queue.Done(item.ID); err != nil {
+		log.Err(err).Uint64("event", item.ID).Msg("failed removing webhook event")
+	}
+}
+
+// RunWebhookWorkers processes the queued webhook events with
+// webhook_workers workers. It returns when ctx is done, after the events
+// being processed stop; the pending ones stay queued.
+func (s *Service) RunWebhookWorkers(ctx context.Context) {
+	workers := s.Config.WebhookWorkers
+	if workers == 0 {
+		workers = conf.DefaultWebhookWorkers
+	}
+	log.Info().Int("workers", workers).Int("pending", s.queue.Len()).Msg("processing webhook events")
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, err := s.queue.Pop(ctx)
+				if err != nil {
+					return
+				}
+				s.handleEvent(ctx, item)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Close releases the resources of the service, once the workers stopped.
+func (s *Service) Close() error {
+	err := s.queue.Close()
+	if s.db != nil {
+		if dbErr := s.db.Close(); err == nil {
+			err = dbErr
+		}
+	}
+	return err
+}
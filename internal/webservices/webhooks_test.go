@@ -0,0 +1,139 @@
+//
+// webhooks_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPostApprovalQueues tests that the events of the configured projects
+// are queued and replied with 202, and that an invalid token is refused.
+func TestPostApprovalQueues(t *testing.T) {
+	events, err := openWebhookQueue(t.TempDir())
+	assert.NoError(t, err)
+	defer events.Close()
+	s := &Service{
+		Config: conf.Config{WebHookToken: "secret", Projects: []conf.ApprovRule{{ProjectId: 3}}},
+		queue:  events,
+	}
+	post := func(token string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/approve", strings.NewReader(body))
+		r.Header.Set("X-Gitlab-Token", token)
+		w := httptest.NewRecorder()
+		s.PostApproval(w, r)
+		return w
+	}
+
+	w := post("secret", `{"object_kind": "merge_request", "object_attributes": {"action": "approved", "iid": 7, "target_project_id": 3}}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 1, events.Len())
+	w = post("secret", `{"object_kind": "pipeline", "project": {"id": 3}, "object_attributes": {"ref": "feature"}}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 2, events.Len())
+	// not a configured project
+	w = post("secret", `{"object_kind": "merge_request", "object_attributes": {"action": "approved", "iid": 7, "target_project_id": 4}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, events.Len())
+
+	// the MR of an event with an invalid token is blocked
+	w = post("wrong", `{"object_kind": "merge_request", "object_attributes": {"action": "approved", "iid": 7, "target_project_id": 3}}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 3, events.Len())
+
+	var kinds []string
+	for i := 0; i < 3; i++ {
+		item, err := events.Pop(context.Background())
+		assert.NoError(t, err)
+		var ev webhookEvent
+		assert.NoError(t, json.Unmarshal(item.Payload, &ev))
+		kinds = append(kinds, ev.Kind)
+	}
+	assert.Equal(t, []string{eventMergeRequest, eventPipeline, eventRejected}, kinds)
+}
+
+// TestHandleEvent tests that a processed event is removed from the queue,
+// a failing one is retried later and one failing for good is dropped.
+func TestHandleEvent(t *testing.T) {
+	var status atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/3/merge_requests/7", func(w http.ResponseWriter, r *http.Request) {
+		if code := int(status.Load()); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte(`{"iid": 7, "project_id": 3, "sha": "sha7", "target_branch": "main"}`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/3/statuses/sha7", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	events, err := openWebhookQueue(t.TempDir())
+	assert.NoError(t, err)
+	defer events.Close()
+	client := gitlab.New(srv.URL, "glpat-token", srv.Client())
+	client.Retries = 0
+	s := &Service{
+		Config: conf.Config{
+			Enforcer:        conf.EnforcerCommitStatus,
+			Projects:        []conf.ApprovRule{{ProjectId: 3}},
+			WebhookDebounce: 1,
+		},
+		gitlab:    client,
+		approvals: &approvalTracker{MRs: map[string]map[string]string{}},
+		queue:     events,
+	}
+	assert.NoError(t, s.queueEvent(webhookEvent{Kind: eventMergeRequest, Body: json.RawMessage(`{"object_kind": "merge_request", "object_attributes": {"action": "update", "iid": 7, "target_project_id": 3}}`)}))
+	ctx := context.Background()
+
+	status.Store(http.StatusBadGateway)
+	item, _ := events.Pop(ctx)
+	s.handleEvent(ctx, item)
+	assert.Equal(t, 1, events.Len())
+	// the retry is delayed
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = events.Pop(short)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	events, err = openWebhookQueue(t.TempDir())
+	assert.NoError(t, err)
+	defer events.Close()
+	s.queue = events
+	status.Store(0)
+	s.queueEvent(webhookEvent{Kind: eventMergeRequest, Body: json.RawMessage(`{"object_attributes": {"action": "update", "iid": 7, "target_project_id": 3}}`)})
+	item, _ = events.Pop(ctx)
+	s.handleEvent(ctx, item)
+	assert.Equal(t, 0, events.Len())
+
+	status.Store(http.StatusNotFound)
+	s.queueEvent(webhookEvent{Kind: eventMergeRequest, Body: json.RawMessage(`{"object_attributes": {"action": "update", "iid": 7, "target_project_id": 3}}`)})
+	item, _ = events.Pop(ctx)
+	s.handleEvent(ctx, item)
+	assert.Equal(t, 0, events.Len())
+}
+
+// TestWebhookBackoff tests the bounds of the retry delay.
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, webhookBackoff(0))
+	assert.Equal(t, 20*time.Second, webhookBackoff(2))
+	assert.Equal(t, 10*time.Minute, webhookBackoff(20))
+	assert.Equal(t, 10*time.Minute, webhookBackoff(100))
+}
@@ -9,63 +9,59 @@
 package webservices
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/cropalato/MergeSentinel/internal/apperr"
 	"github.com/cropalato/MergeSentinel/internal/conf"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/cropalato/MergeSentinel/internal/gitlab"
+	"github.com/cropalato/MergeSentinel/internal/queue"
+	"github.com/cropalato/MergeSentinel/internal/store"
 	"github.com/rs/zerolog/log"
 )
 
 type Service struct {
-	Config conf.Config `json:"config"`
-	HttpClient *http.Client
+	Config       conf.Config `json:"config"`
+	HttpClient   *http.Client
+	gitlab       *gitlab.Client
+	members      *memberCache
+	approvals    *approvalTracker
+	bypasses     *bypassRecorder
+	statusChecks statusCheckIDs
+	summaries    summaryNotes
+	self         currentUser
+	reviewers    *reviewerRotation
+	queue        *queue.Queue
+	db           *store.Store
+	compat       compatState
+	coalescer    mrCoalescer
 }
 
-
-func (s *Service) updateMergeStatus(project_id int, mr_id int, status string, mr_error string) {
-	var merge_error string
-	db, err := sqlx.Connect("postgres", s.Config.PsqlConn)
-	if err != nil {
-		log.Fatal().Err(err).Send()
+// updateMergeStatus writes the merge status of the MR in the gitlab
+// database, unless the database already holds it.
+func (s *Service) updateMergeStatus(ctx context.Context, project_id int, mr_id int, status string, mr_error string) error {
+	if s.dbReadOnly() {
+		log.Warn().Int("project_id", project_id).Int("mr", mr_id).Str("status", status).Str("error", mr_error).Msg("read-only mode, merge status not written")
+		return nil
 	}
-  defer db.Close()
-
-  err = db.Ping()
-  if err != nil {
-		log.Fatal().Err(err).Send()
-  }
-	mr := []MergeRequestData{}
-	query := fmt.Sprintf("SELECT id, target_project_id, iid, description, merge_status, merge_error FROM merge_requests WHERE target_project_id = %d and iid = %d", project_id, mr_id)
-	err = db.Select(&mr, query)
-  if err != nil {
-		log.Fatal().Err(err).Send()
-  }
-	if mr_error == "" {
-		merge_error = "NULL"
-	} else {
-		merge_error = fmt.Sprintf("'%s'", mr_error)
-	}
-	log.Debug().Str("query",query).Any("return",mr).Msg("before update")
-	query = fmt.Sprintf("UPDATE %s SET %s = '%s', %s = %s WHERE %s = %d AND %s = %d",
-	                    "merge_requests", "merge_status", status, "merge_error", merge_error,
-											"target_project_id", project_id, "iid", mr_id)
-	_, err = db.Exec(query)
-  if err != nil {
-		log.Fatal().Err(err).Send()
-  }
+	return s.db.UpdateMergeStatus(ctx, project_id, mr_id, status, mr_error)
 }
 
-
+// LoadConfig loads the config file and creates the service. Every error is
+// of kind apperr.Config.
 func LoadConfig(cfg_path string) (*Service, error) {
 	var s Service
-	c, e := conf.NewConfig(cfg_path)
+	c, err := conf.NewConfig(cfg_path)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Config, err)
+	}
 	log.Debug().Str("file", cfg_path).Interface("config", c).Send()
 	t := http.DefaultTransport.(*http.Transport).Clone()
 	t.MaxIdleConns = 100
@@ -76,171 +72,482 @@ func LoadConfig(cfg_path string) (*Service, error) {
 		Timeout:   10 * time.Second,
 		Transport: t,
 	}
+	ttl := c.GroupCacheTTL
+	if ttl == 0 {
+		ttl = conf.DefaultGroupCacheTTL
+	}
+	tracker, err := newApprovalTracker(c.StateDir)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Config, err)
+	}
+	bypasses, err := newBypassRecorder(c.StateDir)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Config, err)
+	}
+	reviewers, err := newReviewerRotation(c.StateDir)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Config, err)
+	}
+	events, err := openWebhookQueue(c.StateDir)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Config, err)
+	}
+	var db *store.Store
+	if c.PsqlConn != "" {
+		if db, err = store.Open(c.PsqlConn, poolOptions(*c)); err != nil {
+			return nil, err
+		}
+	}
 	s = Service{
-		Config: *c,
+		Config:     *c,
 		HttpClient: h,
+		gitlab:     gitlab.New(c.GitlabURL, c.GitlabToken, h),
+		members:    newMemberCache(time.Duration(ttl) * time.Second),
+		approvals:  tracker,
+		bypasses:   bypasses,
+		reviewers:  reviewers,
+		queue:      events,
+		db:         db,
+	}
+	// not ready until checked and registered at startup
+	if s.usesDBEnforcer() {
+		s.compat.set("not checked yet", nil)
 	}
-	return &s, e
+	if s.usesStatusCheckEnforcer() {
+		s.statusChecks.setFailure("not registered yet")
+	}
+	return &s, nil
 }
 
+// poolOptions returns the database pool options of the config, with the
+// defaults of the options not defined.
+func poolOptions(c conf.Config) store.PoolOptions {
+	opts := store.PoolOptions{
+		MaxOpenConns:    c.DBMaxOpenConns,
+		MaxIdleConns:    c.DBMaxIdleConns,
+		ConnMaxLifetime: time.Duration(c.DBConnMaxLifetime) * time.Second,
+	}
+	if opts.MaxOpenConns == 0 {
+		opts.MaxOpenConns = conf.DefaultDBMaxOpenConns
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = conf.DefaultDBMaxIdleConns
+	}
+	if opts.MaxIdleConns > opts.MaxOpenConns {
+		opts.MaxIdleConns = opts.MaxOpenConns
+	}
+	if opts.ConnMaxLifetime == 0 {
+		opts.ConnMaxLifetime = conf.DefaultDBConnMaxLifetime * time.Second
+	}
+	return opts
+}
 
-func (s *Service) reinforceMrRule(ar conf.ApprovRule, mr_id int) error {
-	  var approvals GitlabApproval
-		log.Debug().Int("project_id", ar.ProjectId).Int("mr", mr_id).Msg("reinforcing MR rule")
-		url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests/%d/approvals", strings.Trim(s.Config.GitlabURL, "/"), ar.ProjectId, mr_id)
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			log.Err(err).Send()
-			return err
+func (s *Service) reinforceMrRule(ctx context.Context, ar conf.ApprovRule, mr_id int) error {
+	mr, ev, err := s.evaluateMrRule(ctx, ar, mr_id)
+	if err != nil {
+		return err
+	}
+	return s.applyEvaluation(ctx, ar, mr, ev)
+}
+
+// evaluateMrRule loads the MR and evaluates it against the project rule.
+func (s *Service) evaluateMrRule(ctx context.Context, ar conf.ApprovRule, mr_id int) (gitlab.MergeRequest, Evaluation, error) {
+	var approvals gitlab.Approvals
+	log.Debug().Int("project_id", ar.ProjectId).Int("mr", mr_id).Msg("reinforcing MR rule")
+	mr, err := s.gitlab.MergeRequest(ctx, ar.ProjectId, mr_id)
+	if err != nil {
+		log.Err(err).Send()
+		return mr, Evaluation{}, err
+	}
+	var ev Evaluation
+	rule, ok := ar.MatchBranch(mr.TargetBranch)
+	if !ok {
+		log.Debug().Int("project_id", ar.ProjectId).Int("mr", mr_id).Str("target_branch", mr.TargetBranch).Bool("block", ar.BlockUnmatched()).Msg("no rule for target branch")
+		if ar.BlockUnmatched() {
+			ev.Failures = append(ev.Failures, fmt.Sprintf("No approval rule matches target branch '%s'", mr.TargetBranch))
+			return mr, ev, nil
+		}
+	}
+	labels := labelNames(mr.Labels)
+	groups, labelFailures, bypasses := applyLabels(rule.Labels, labels, rule.ApproverGroups())
+	if len(bypasses) > 0 {
+		names := make([]string, 0, len(bypasses))
+		for _, b := range bypasses {
+			names = append(names, b.Label)
 		}
-    req.Header.Add("Accept", "application/json")
-    req.Header.Add("PRIVATE-TOKEN", s.Config.GitlabToken)
-		log.Debug().Str("url", req.URL.String()).Msg("calling gitlab")
-		resp, err := s.HttpClient.Do(req)
+		s.recordBypasses(ctx, mr, ar.ProjectId, names)
+	}
+	if ar.HasPathRules() {
+		files, err := s.changedFiles(ctx, ar.ProjectId, mr_id)
 		if err != nil {
 			log.Err(err).Send()
-			return err
+			return mr, ev, err
 		}
-		body, err := io.ReadAll(resp.Body)
+		groups = append(groups, ar.PathGroups(files)...)
+	}
+	if len(groups) > 0 {
+		approvals, err = s.gitlab.Approvals(ctx, ar.ProjectId, mr_id)
 		if err != nil {
 			log.Err(err).Send()
-			return err
-		}
-		if err := json.Unmarshal(body, &approvals); err != nil {
-			log.Fatal().Err(err).Send()
-		}
-		resp.Body.Close()
-		pending := ar.MinApprov
-		for _, by := range approvals.ApprovedBy {
-		  for _, a := range ar.Approvals {
-				if a == by.User.Username {
-					pending--
-					break
-				}
-			}
+			return mr, ev, err
 		}
-		if pending == 0 {
-			log.Debug().Int("project_id", ar.ProjectId).Int("mr", mr_id).Msg("ok to be merged")
-			s.updateMergeStatus(ar.ProjectId, mr_id, "can_be_merged", "")
-		} else {
-			log.Debug().Int("project_id", ar.ProjectId).Int("mr", mr_id).Msg("not ready to be merged")
-			msg := fmt.Sprintf("Requires at least %d approvals from %v", ar.MinApprov, ar.Approvals)
-			s.updateMergeStatus(ar.ProjectId, mr_id, "cannot_be_merged", msg)
+	}
+	approvedBy := make([]string, 0, len(approvals.ApprovedBy))
+	for _, by := range approvals.ApprovedBy {
+		approvedBy = append(approvedBy, by.User.Username)
+	}
+	excluded, err := s.excludedApprovers(ctx, ar.ProjectId, mr, rule)
+	if err != nil {
+		log.Err(err).Send()
+		return mr, ev, err
+	}
+	if rule.ResetOnPush {
+		for by, reason := range s.approvals.staleApprovals(ar.ProjectId, mr_id, mr.Sha, approvedBy) {
+			if _, ok := excluded[by]; !ok {
+				excluded[by] = reason
+			}
 		}
-	return nil
+	}
+	members, err := s.resolveGroupApprovers(ctx, groups)
+	if err != nil {
+		log.Err(err).Send()
+		return mr, ev, err
+	}
+	ev = evaluateGroups(groups, approvedBy, members, excluded)
+	freezeFailures, err := s.freezeFailures(ctx, ar.ProjectId, mr, labels, time.Now())
+	if err != nil {
+		log.Err(err).Send()
+		return mr, ev, err
+	}
+	ev.Failures = append(ev.Failures, freezeFailures...)
+	ev.Failures = append(ev.Failures, labelFailures...)
+	ev.Failures = append(ev.Failures, conditionFailures(rule, mr)...)
+	if rule.RequirePipeline {
+		ev.Failures = append(ev.Failures, pipelineFailures(mr)...)
+	}
+	log.Debug().Int("project_id", ar.ProjectId).Int("mr", mr_id).Str("pattern", rule.Pattern).Bool("mergeable", ev.Mergeable()).Msg("MR rule evaluated")
+	return mr, ev, nil
 }
 
+// reinforceNewMr reinforces the rule of a MR just opened and, if enabled,
+// assigns the approvers still needed as reviewers.
+func (s *Service) reinforceNewMr(ctx context.Context, ar conf.ApprovRule, mr_id int) error {
+	mr, ev, err := s.evaluateMrRule(ctx, ar, mr_id)
+	if err != nil {
+		return err
+	}
+	err = s.applyEvaluation(ctx, ar, mr, ev)
+	if err := s.assignReviewers(ctx, ar, mr, ev); err != nil {
+		log.Err(err).Int("project_id", ar.ProjectId).Int("mr", mr_id).Msg("failed assigning reviewers")
+	}
+	return err
+}
 
-func (s *Service) ReinforceAllMrRule() error {
-	var mrList []GitlabMR
-	for _, p := range s.Config.Projects {
-		log.Debug().Int("project_id", p.ProjectId).Msg("reinforcing MR rule")
-		url := fmt.Sprintf("%s/api/v4/projects/%d/merge_requests", strings.Trim(s.Config.GitlabURL, "/"), p.ProjectId)
-		req, err := http.NewRequest("GET", url, nil)
+// applyEvaluation enforces the evaluation of the MR and, if enabled, updates
+// its summary note.
+func (s *Service) applyEvaluation(ctx context.Context, ar conf.ApprovRule, mr gitlab.MergeRequest, ev Evaluation) error {
+	err := s.enforce(ctx, ar, mr, ev)
+	if s.Config.ProjectSummaryNote(ar) {
+		if err := s.updateSummaryNote(ctx, ar.ProjectId, mr.Iid, ev); err != nil {
+			log.Err(err).Int("project_id", ar.ProjectId).Int("mr", mr.Iid).Msg("failed updating summary note")
+		}
+	}
+	return err
+}
+
+// excludedApprovers returns the users whose approval must not count for the
+// MR, with the reason, according to the rule exclude_* options.
+func (s *Service) excludedApprovers(ctx context.Context, project_id int, mr gitlab.MergeRequest, rule conf.BranchRule) (map[string]string, error) {
+	excluded := map[string]string{}
+	if rule.ExcludeCommitters {
+		committers, err := s.committers(ctx, project_id, mr.Iid)
 		if err != nil {
-			log.Err(err).Send()
+			return nil, err
+		}
+		for _, c := range committers {
+			excluded[c] = "pushed commits to the MR"
+		}
+	}
+	if rule.ExcludeAuthor && mr.Author.Username != "" {
+		excluded[mr.Author.Username] = "author of the MR"
+	}
+	return excluded, nil
+}
+
+// committers returns the usernames of the authors and committers of the MR
+// commits. Commits only hold emails, so they are resolved with the users
+// API; emails not linked to a gitlab account are ignored.
+func (s *Service) committers(ctx context.Context, project_id int, mr_id int) ([]string, error) {
+	commits, err := s.gitlab.Commits(ctx, project_id, mr_id)
+	if err != nil {
+		return nil, err
+	}
+	emails := map[string]bool{}
+	for _, c := range commits {
+		emails[strings.ToLower(c.AuthorEmail)] = true
+		emails[strings.ToLower(c.CommitterEmail)] = true
+	}
+	var usernames []string
+	for email := range emails {
+		if email == "" {
 			continue
 		}
-    req.Header.Add("Accept", "application/json")
-    req.Header.Add("PRIVATE-TOKEN", s.Config.GitlabToken)
-		req.URL.Query().Add("state", "opened")
-		req.URL.RawQuery = req.URL.Query().Encode()
-		log.Debug().Str("url", req.URL.String()).Msg("calling gitlab")
-		resp, err := s.HttpClient.Do(req)
+		users, err := s.gitlab.Users(ctx, url.Values{"search": {email}})
 		if err != nil {
-			log.Err(err).Send()
-			continue
+			return nil, err
+		}
+		for _, u := range users {
+			usernames = append(usernames, u.Username)
 		}
-		body, err := io.ReadAll(resp.Body)
+	}
+	return usernames, nil
+}
+
+// changedFiles returns the paths, old and new, of all files changed by the MR.
+func (s *Service) changedFiles(ctx context.Context, project_id int, mr_id int) ([]string, error) {
+	diffs, err := s.gitlab.Diffs(ctx, project_id, mr_id)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, d := range diffs {
+		files = append(files, d.NewPath)
+		if d.OldPath != d.NewPath {
+			files = append(files, d.OldPath)
+		}
+	}
+	return files, nil
+}
+
+// openedMRs returns the opened MRs of the project.
+func (s *Service) openedMRs(ctx context.Context, project_id int) ([]gitlab.MergeRequest, error) {
+	return s.gitlab.MergeRequests(ctx, project_id, url.Values{"state": {"opened"}})
+}
+
+// ReinforceAllMrRule reinforces the rule of every opened MR of every
+// project. The MRs failing do not stop the others; their errors are
+// returned joined.
+func (s *Service) ReinforceAllMrRule(ctx context.Context) error {
+	var errs []error
+	for _, p := range s.Config.Projects {
+		log.Debug().Int("project_id", p.ProjectId).Msg("reinforcing MR rule")
+		mrList, err := s.openedMRs(ctx, p.ProjectId)
 		if err != nil {
 			log.Err(err).Send()
+			recordError(err)
+			errs = append(errs, err)
 			continue
 		}
-		if err := json.Unmarshal(body, &mrList); err != nil {
-			log.Fatal().Err(err).Send()
-		}
-		resp.Body.Close()
 		for _, mr := range mrList {
-      err := s.reinforceMrRule(p, mr.Iid)
+			err := s.serializeMr(ctx, p, mr.Iid)
 			if err != nil {
 				log.Err(err).Send()
+				recordError(err)
+				errs = append(errs, err)
 				continue
 			}
 		}
 	}
 	log.Debug().Msg("all MR rules reinforced")
-	return nil
+	return errors.Join(errs...)
 }
 
+// stateTimeout bounds the checks of the State handler.
+const stateTimeout = 2 * time.Second
+
+// ServiceState is the reply of the State handler.
+type ServiceState struct {
+	Status string `json:"status"`
+	// Database is the health of the database pool, if used.
+	Database *store.Health `json:"database,omitempty"`
+	// DBProblems lists why the db enforcer is in read-only mode.
+	DBProblems []string `json:"db_problems,omitempty"`
+	// DBUnchecked is why the db enforcer compatibility could not be checked
+	// yet, keeping it in read-only mode.
+	DBUnchecked string `json:"db_unchecked,omitempty"`
+}
 
-// State is used to check is the service is running and health.
+// State is used to check is the service is running and health. It replies
+// 503 when the database is unreachable.
 func (s *Service) State(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", s.Config.CorsOrigin)
 	if r.Method == http.MethodOptions {
 		return
 	}
-	// TODO: Add code to validate if service is ready to reply requests
-	w.WriteHeader(200)
-	_, err := w.Write([]byte("Service is ready"))
-	if err != nil {
-		log.Err(err).Send()
+	state := ServiceState{Status: "ready"}
+	state.DBUnchecked, state.DBProblems = s.compat.get()
+	code := http.StatusOK
+	if s.db != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), stateTimeout)
+		defer cancel()
+		health := s.db.Health(ctx)
+		state.Database = &health
+		if !health.Up {
+			state.Status = "database unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	replyJSON(w, code, state)
+}
+
+// evaluatesMR reports if the merge request event can change the MR merge
+// status, e.g. approvals, pushes or draft status changes.
+func evaluatesMR(callback GitlabMREventWebhookCallback) bool {
+	switch callback.ObjectAttributes.Action {
+	case "open", "reopen", "update", "approved", "approval", "unapproved", "unapproval":
+		return true
 	}
+	return false
 }
 
+// trackApproval keeps the approval tracker in sync with the merge request event.
+func (s *Service) trackApproval(callback GitlabMREventWebhookCallback) {
+	project_id := callback.ObjectAttributes.TargetProjectID
+	mr_id := callback.ObjectAttributes.Iid
+	switch callback.ObjectAttributes.Action {
+	case "approved", "approval":
+		s.approvals.recordApproval(project_id, mr_id, callback.User.Username, callback.ObjectAttributes.LastCommit.ID)
+	case "unapproved", "unapproval":
+		s.approvals.removeApproval(project_id, mr_id, callback.User.Username)
+	case "merge", "close":
+		s.approvals.forget(project_id, mr_id)
+	}
+}
 
-// PostApproval validate if MR has enough approvals.
-// It will return unauthorized http code if rule do not match the required condition.
+// checkWebhookToken validates the webhook request token against the one
+// configured for the project, or globally.
+func (s *Service) checkWebhookToken(p conf.ApprovRule, request_token string) error {
+	tmp_token := p.WebHookToken
+	if tmp_token == "" {
+		tmp_token = s.Config.WebHookToken
+	}
+	if (request_token != "" && tmp_token != "" && request_token != tmp_token) ||
+		(request_token == "" && tmp_token != "") {
+		return apperr.New(apperr.Policy, "mismatching webhook and local tokens.")
+	}
+	if request_token != "" && tmp_token == "" {
+		log.Warn().Msg("Callback with 'X-Gitlab-Token' header, but missing local token config to validate.")
+	}
+	return nil
+}
+
+// PostApproval queues the merge request and pipeline events, replying 202
+// right away; the workers started by RunWebhookWorkers validate if the MRs
+// have enough approvals. It replies 403 if the webhook token is invalid,
+// and blocks the MR of the event.
 func (s *Service) PostApproval(w http.ResponseWriter, r *http.Request) {
-	var tmp_token string
 	// w.Header().Set("Access-Control-Allow-Origin", c.CorsOrigin)
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if r.Method == http.MethodOptions {
 		return
 	}
-	request_token, exist := r.Header["X-Gitlab-Token"]
-	if ! exist {
+	if _, exist := r.Header["X-Gitlab-Token"]; !exist {
 		log.Warn().Msg("Missing 'X-Gitlab-Token' header.")
 	}
+	request_token := r.Header.Get("X-Gitlab-Token")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Err(err).Send()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var kind struct {
+		ObjectKind string `json:"object_kind"`
+	}
+	if err := json.Unmarshal(body, &kind); err != nil {
+		log.Err(err).Send()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if kind.ObjectKind == "pipeline" {
+		s.postPipeline(w, body, request_token)
+		return
+	}
 	var callback GitlabMREventWebhookCallback
-	err := json.NewDecoder(r.Body).Decode(&callback)
+	err = json.Unmarshal(body, &callback)
 	if err != nil {
 		log.Err(err).Send()
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	cb_obj    := callback.ObjectKind
+	cb_obj := callback.ObjectKind
 	cb_action := callback.ObjectAttributes.Action
 	cb_mr_id := callback.ObjectAttributes.Iid
 	cb_project := callback.ObjectAttributes.TargetProjectID
 	cm_user := callback.User.Username
 	log.Debug().Str("user", cm_user).Str("action", cb_action).Str("object", cb_obj).Int("project", cb_project).Int("mr_id", cb_mr_id).Msg("Callback received")
-	if cb_action == "open" || cb_action == "reopen" || cb_action == "approved" || cb_action == "unapproved" {
+	queued := false
+	if evaluatesMR(callback) || cb_action == "merge" || cb_action == "close" {
 		for _, p := range s.Config.Projects {
-			log.Debug().Int("p.ProjectId",p.ProjectId).Int("cb_project",cb_project).Send()
-			if p.ProjectId == cb_project {
-				tmp_token = p.WebHookToken
-				if tmp_token == "" {
-					tmp_token = s.Config.WebHookToken
-				}
-				if (request_token[0] != "" && tmp_token != "" && request_token[0] != tmp_token) ||
-				     (request_token[0] == "" && tmp_token != "") {
-					err_msg := "mismatching webhook and local tokens."
-					err := errors.New(err_msg)
-					log.Error().Err(err).Send()
-		      s.updateMergeStatus(cb_project, cb_mr_id, "cannot_be_merged", err_msg)
-		      http.Error(w, err.Error(), http.StatusBadRequest)
-      		return
-				}
-				if request_token[0] != "" && tmp_token == "" {
-					log.Warn().Msg("Callback with 'X-Gitlab-Token' header, but missing local token config to validate." )
+			log.Debug().Int("p.ProjectId", p.ProjectId).Int("cb_project", cb_project).Send()
+			if p.ProjectId != cb_project {
+				continue
+			}
+			if err := s.checkWebhookToken(p, request_token); err != nil {
+				log.Error().Err(err).Send()
+				if err := s.queueEvent(webhookEvent{Kind: eventRejected, ProjectID: cb_project, Iid: cb_mr_id, Reason: err.Error()}); err != nil {
+					log.Err(err).Msg("failed queuing webhook event")
 				}
-				s.reinforceMrRule(p, cb_mr_id)
+				replyError(w, err)
+				return
 			}
+			queued = true
 		}
 	}
+	if !queued {
+		replyEvent(w, http.StatusOK, "Merge event received")
+		return
+	}
+	if err := s.queueEvent(webhookEvent{Kind: eventMergeRequest, Body: body}); err != nil {
+		log.Err(err).Msg("failed queuing webhook event")
+		http.Error(w, "failed queuing event", http.StatusServiceUnavailable)
+		return
+	}
+	replyEvent(w, http.StatusAccepted, "Merge event queued")
+}
+
+// postPipeline queues the pipeline events of the configured projects.
+func (s *Service) postPipeline(w http.ResponseWriter, body []byte, request_token string) {
+	var callback GitlabPipelineEventWebhookCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		log.Err(err).Send()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cb_project := callback.Project.ID
+	if callback.MergeRequest != nil {
+		cb_project = callback.MergeRequest.TargetProjectID
+	}
+	log.Debug().Int("project", cb_project).Int("pipeline", callback.ObjectAttributes.ID).Str("status", callback.ObjectAttributes.Status).Msg("Pipeline callback received")
+	queued := false
+	for _, p := range s.Config.Projects {
+		if p.ProjectId != cb_project {
+			continue
+		}
+		if err := s.checkWebhookToken(p, request_token); err != nil {
+			log.Error().Err(err).Send()
+			replyError(w, err)
+			return
+		}
+		queued = true
+	}
+	if !queued {
+		replyEvent(w, http.StatusOK, "Pipeline event received")
+		return
+	}
+	if err := s.queueEvent(webhookEvent{Kind: eventPipeline, Body: body}); err != nil {
+		log.Err(err).Msg("failed queuing webhook event")
+		http.Error(w, "failed queuing event", http.StatusServiceUnavailable)
+		return
+	}
+	replyEvent(w, http.StatusAccepted, "Pipeline event queued")
+}
+
+// replyEvent replies to a webhook call with status and the json message msg.
+func replyEvent(w http.ResponseWriter, status int, msg string) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	_, err = w.Write([]byte("{ \"msg\": \"Merge event received\" }\n"))
+	w.WriteHeader(status)
+	_, err := fmt.Fprintf(w, "{ \"msg\": %q }\n", msg)
 	if err != nil {
 		log.Err(err).Send()
 	}
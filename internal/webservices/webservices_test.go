@@ -0,0 +1,50 @@
+//
+// webservices_test.go
+// Copyright (C) 2024 rmelo <Ricardo Melo <rmelo@ludia.com>>
+//
+// Distributed under terms of the MIT license.
+//
+
+package webservices
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cropalato/MergeSentinel/internal/conf"
+	"github.com/cropalato/MergeSentinel/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPoolOptions tests the defaults of the database pool options.
+func TestPoolOptions(t *testing.T) {
+	opts := poolOptions(conf.Config{})
+	assert.Equal(t, store.PoolOptions{MaxOpenConns: 5, MaxIdleConns: 2, ConnMaxLifetime: 5 * time.Minute}, opts)
+	opts = poolOptions(conf.Config{DBMaxOpenConns: 1, DBConnMaxLifetime: 60})
+	assert.Equal(t, store.PoolOptions{MaxOpenConns: 1, MaxIdleConns: 1, ConnMaxLifetime: time.Minute}, opts)
+}
+
+// TestState tests that the state reports the database health, and 503 when
+// it is unreachable.
+func TestState(t *testing.T) {
+	s := &Service{}
+	w := httptest.NewRecorder()
+	s.State(w, httptest.NewRequest(http.MethodGet, "/state", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ready"}`, w.Body.String())
+
+	db, err := store.Open("postgres://user@127.0.0.1:1/gitlabhq_production?sslmode=disable&connect_timeout=1", poolOptions(conf.Config{}))
+	assert.NoError(t, err)
+	defer db.Close()
+	s.db = db
+	w = httptest.NewRecorder()
+	s.State(w, httptest.NewRequest(http.MethodGet, "/state", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var state ServiceState
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.False(t, state.Database.Up)
+	assert.Equal(t, 5, state.Database.MaxOpenConnections)
+}